@@ -6,9 +6,13 @@ require go.etcd.io/etcd/client/v3 v3.5.1
 
 require (
 	github.com/go-redis/redis/v8 v8.11.4
+	github.com/golang-jwt/jwt/v4 v4.3.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/pion/rtcp v1.2.3
 	github.com/pion/rtp v1.6.0
+	github.com/pion/sdp/v2 v2.4.0
 	github.com/pion/webrtc/v2 v2.2.26
+	github.com/prometheus/client_golang v1.12.1
 	github.com/spf13/viper v1.10.1
 	github.com/zhuanxin-sz/go-protoo v0.1.5
 	github.com/zhuanxin-sz/nats-protoo v0.1.2
@@ -29,6 +29,28 @@ const (
 	ClientToBizGetRoomUsers = "getusers"
 	// ClientToBizGetRoomPubs C->Biz 获取房间所有用户流数据
 	ClientToBizGetRoomPubs = "getpubs"
+	// ClientToBizSetRole C->Biz 修改用户角色
+	ClientToBizSetRole = "setrole"
+	// ClientToBizMessage C->Biz 发送消息给指定用户
+	ClientToBizMessage = "message"
+	// ClientToBizSetState C->Biz 设置房间状态
+	ClientToBizSetState = "setstate"
+	// ClientToBizGetState C->Biz 获取房间状态
+	ClientToBizGetState = "getstate"
+	// ClientToBizDelState C->Biz 删除房间状态
+	ClientToBizDelState = "delstate"
+	// ClientToBizUpdateInfo C->Biz 修改用户信息
+	ClientToBizUpdateInfo = "updateinfo"
+	// ClientToBizKick C->Biz 踢出用户
+	ClientToBizKick = "kick"
+	// ClientToBizStreamUpdate C->Biz 修改流信息
+	ClientToBizStreamUpdate = "streamupdate"
+	// ClientToBizMute C->Biz 请求用户静音
+	ClientToBizMute = "mute"
+	// ClientToBizHistory C->Biz 获取房间广播历史
+	ClientToBizHistory = "history"
+	// ClientToBizSetLayer C->Biz 选择订阅流的simulcast层
+	ClientToBizSetLayer = "setlayer"
 
 	// BizToClientOnJoin Biz->C 有人加入房间
 	BizToClientOnJoin = "peer-join"
@@ -42,6 +64,20 @@ const (
 	BizToClientBroadcast = "broadcast"
 	// BizToClientOnKick Biz->C 被服务器踢下线
 	BizToClientOnKick = "peer-kick"
+	// BizToClientOnRoleChange Biz->C 有人角色变化
+	BizToClientOnRoleChange = "peer-role"
+	// BizToClientMessage Biz->C 有人发送消息给自己
+	BizToClientMessage = "message"
+	// BizToClientOnStateChange Biz->C 房间状态变化
+	BizToClientOnStateChange = "state-change"
+	// BizToClientOnUpdate Biz->C 有人修改用户信息
+	BizToClientOnUpdate = "peer-update"
+	// BizToClientOnStreamUpdate Biz->C 有人修改流信息
+	BizToClientOnStreamUpdate = "stream-update"
+	// BizToClientSystem Biz->C 系统通知
+	BizToClientSystem = "system"
+	// BizToClientMute Biz->C 请求客户端静音
+	BizToClientMute = ClientToBizMute
 
 	/*
 		biz与biz服务器通信
@@ -59,6 +95,20 @@ const (
 	BizToBizBroadcast = BizToClientBroadcast
 	// BizToBizOnKick biz->biz 被服务器踢下线
 	BizToBizOnKick = BizToClientOnKick
+	// BizToBizOnRoleChange biz->biz 有人角色变化
+	BizToBizOnRoleChange = BizToClientOnRoleChange
+	// BizToBizMessage biz->biz 发送消息给指定用户
+	BizToBizMessage = BizToClientMessage
+	// BizToBizOnStateChange biz->biz 房间状态变化
+	BizToBizOnStateChange = BizToClientOnStateChange
+	// BizToBizOnUpdate biz->biz 有人修改用户信息
+	BizToBizOnUpdate = BizToClientOnUpdate
+	// BizToBizOnStreamUpdate biz->biz 有人修改流信息
+	BizToBizOnStreamUpdate = BizToClientOnStreamUpdate
+	// BizToBizSystem biz->biz 系统通知
+	BizToBizSystem = BizToClientSystem
+	// BizToBizMute biz->biz 请求其他节点的用户静音
+	BizToBizMute = BizToClientMute
 
 	/*
 		biz与sfu服务器通信
@@ -72,6 +122,10 @@ const (
 	BizToSfuSubscribe = ClientToBizSubscribe
 	// BizToSfuUnSubscribe Biz->Sfu 取消订阅流
 	BizToSfuUnSubscribe = ClientToBizUnSubscribe
+	// BizToSfuMute Biz->Sfu 停止或恢复转发流
+	BizToSfuMute = ClientToBizMute
+	// BizToSfuSetLayer Biz->Sfu 选择订阅流的simulcast层
+	BizToSfuSetLayer = ClientToBizSetLayer
 	// SfuToBizOnStreamRemove Sfu->Biz Sfu通知biz流被移除
 	SfuToBizOnStreamRemove = "sfu-stream-remove"
 
@@ -89,6 +143,8 @@ const (
 	BizToIslbOnStreamAdd = "stream-add"
 	// BizToIslbOnStreamRemove biz->islb 有人停止推流
 	BizToIslbOnStreamRemove = "stream-remove"
+	// BizToIslbOnStreamUpdate biz->islb 有人修改流信息
+	BizToIslbOnStreamUpdate = "stream-update"
 	// BizToIslbGetBizInfo biz->islb 根据uid查询对应的biz
 	BizToIslbGetBizInfo = "getBizInfo"
 	// BizToIslbGetSfuInfo biz->islb 根据mid查询对应的sfu
@@ -97,6 +153,22 @@ const (
 	BizToIslbGetRoomUsers = "getRoomUsers"
 	// BizToIslbGetRoomPubs biz->islb 获取房间其他用户推流数据
 	BizToIslbGetRoomPubs = "getRoomPubs"
+	// BizToIslbSetState biz->islb 设置房间状态
+	BizToIslbSetState = "setState"
+	// BizToIslbGetState biz->islb 获取房间状态
+	BizToIslbGetState = "getState"
+	// BizToIslbDelState biz->islb 删除房间状态
+	BizToIslbDelState = "delState"
+	// BizToIslbUpdateInfo biz->islb 修改用户信息
+	BizToIslbUpdateInfo = "updateInfo"
+	// BizToIslbSetBlock biz->islb 禁止用户加入房间
+	BizToIslbSetBlock = "setBlock"
+	// BizToIslbGetBlock biz->islb 查询用户是否被禁止加入房间
+	BizToIslbGetBlock = "getBlock"
+	// BizToIslbAddHistory biz->islb 保存房间广播
+	BizToIslbAddHistory = "addHistory"
+	// BizToIslbGetHistory biz->islb 获取房间广播历史
+	BizToIslbGetHistory = "getHistory"
 )
 
 // GetUIDFromMID 从mid中获取uid
@@ -109,11 +181,41 @@ func GetUserNodeKey(rid, uid string) string {
 	return "/node/rid/" + rid + "/uid/" + uid
 }
 
+// GetUserInfoKey 获取用户的信息
+func GetUserInfoKey(rid, uid string) string {
+	return "/info/rid/" + rid + "/uid/" + uid
+}
+
+// GetUserBlockKey 获取用户的封禁信息
+func GetUserBlockKey(rid, uid string) string {
+	return "/block/rid/" + rid + "/uid/" + uid
+}
+
 // GetMediaInfoKey 获取用户流的信息
 func GetMediaInfoKey(rid, uid, mid string) string {
 	return "/media/rid/" + rid + "/uid/" + uid + "/mid/" + mid
 }
 
+// GetRoomPeersKey 获取房间人数计数
+func GetRoomPeersKey(rid string) string {
+	return "/peers/rid/" + rid
+}
+
+// GetRoomPublishersKey 获取房间推流人数计数
+func GetRoomPublishersKey(rid string) string {
+	return "/publishers/rid/" + rid
+}
+
+// GetRoomHistoryKey 获取房间广播历史
+func GetRoomHistoryKey(rid string) string {
+	return "/history/rid/" + rid
+}
+
+// GetRoomStateKey 获取房间状态数据
+func GetRoomStateKey(rid string) string {
+	return "/state/rid/" + rid
+}
+
 // GetMediaPubKey 获取用户流的sfu服务器
 func GetMediaPubKey(rid, uid, mid string) string {
 	return "/pub/rid/" + rid + "/uid/" + uid + "/mid/" + mid
@@ -170,3 +170,37 @@ func (r *Redis) HGetAll(k string) map[string]string {
 	}
 	return r.single.HGetAll(context.Background(), k).Val()
 }
+
+// ZRem redis从有序集合中删除成员
+func (r *Redis) ZRem(k string, members ...interface{}) error {
+	if r.clusterMode {
+		return r.cluster.ZRem(context.Background(), k, members...).Err()
+	}
+	return r.single.ZRem(context.Background(), k, members...).Err()
+}
+
+// ZRangeByScore redis按分数从小到大获取有序集合成员
+func (r *Redis) ZRangeByScore(k, min, max string, count int64) []string {
+	opt := &redis.ZRangeBy{Min: min, Max: max, Count: count}
+	if r.clusterMode {
+		return r.cluster.ZRangeByScore(context.Background(), k, opt).Val()
+	}
+	return r.single.ZRangeByScore(context.Background(), k, opt).Val()
+}
+
+// ZRevRangeByScore redis按分数从大到小获取有序集合成员
+func (r *Redis) ZRevRangeByScore(k, max, min string, count int64) []string {
+	opt := &redis.ZRangeBy{Min: min, Max: max, Count: count}
+	if r.clusterMode {
+		return r.cluster.ZRevRangeByScore(context.Background(), k, opt).Val()
+	}
+	return r.single.ZRevRangeByScore(context.Background(), k, opt).Val()
+}
+
+// Eval redis执行lua脚本
+func (r *Redis) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	if r.clusterMode {
+		return r.cluster.Eval(context.Background(), script, keys, args...).Result()
+	}
+	return r.single.Eval(context.Background(), script, keys, args...).Result()
+}
@@ -18,6 +18,14 @@ var (
 	Signal = &cfg.Signal
 	// Nats 消息中间件设置
 	Nats = &cfg.Nats
+	// Auth 鉴权设置
+	Auth = &cfg.Auth
+	// Room 房间设置
+	Room = &cfg.Room
+	// Admin 管理接口设置
+	Admin = &cfg.Admin
+	// Webhook 事件回调设置
+	Webhook = &cfg.Webhook
 )
 
 func init() {
@@ -43,17 +51,60 @@ type nats struct {
 }
 
 type signal struct {
-	Host string `mapstructure:"host"`
-	Port int    `mapstructure:"port"`
-	Cert string `mapstructure:"cert"`
-	Key  string `mapstructure:"key"`
+	Host      string `mapstructure:"host"`
+	Port      int    `mapstructure:"port"`
+	TlsPort   int    `mapstructure:"tlsport"`
+	WsPort    int    `mapstructure:"wsport"`
+	Cert      string `mapstructure:"cert"`
+	Key       string `mapstructure:"key"`
+	MaxFrame  int    `mapstructure:"maxframe"`
+	Idle      int    `mapstructure:"idle"`
+	SendQueue int    `mapstructure:"sendqueue"`
+	Overflow  string `mapstructure:"overflow"`
+	Resume    int    `mapstructure:"resume"`
+}
+
+type auth struct {
+	Secret string `mapstructure:"secret"`
+}
+
+type room struct {
+	Role     string           `mapstructure:"role"`
+	MaxInfo  int              `mapstructure:"maxinfo"`
+	MaxPeers int              `mapstructure:"maxpeers"`
+	MaxPubs  int              `mapstructure:"maxpubs"`
+	Limits   map[string]limit `mapstructure:"limits"`
+}
+
+type limit struct {
+	Role     string `mapstructure:"role"`
+	MaxPeers int    `mapstructure:"maxpeers"`
+	MaxPubs  int    `mapstructure:"maxpubs"`
+}
+
+type admin struct {
+	Token string `mapstructure:"token"`
+}
+
+type webhook struct {
+	URL     string   `mapstructure:"url"`
+	Secret  string   `mapstructure:"secret"`
+	Events  []string `mapstructure:"events"`
+	Timeout int      `mapstructure:"timeout"`
+	Retry   int      `mapstructure:"retry"`
+	Backoff int      `mapstructure:"backoff"`
+	Queue   int      `mapstructure:"queue"`
 }
 
 type config struct {
-	Global  global `mapstructure:"global"`
-	Etcd    etcd   `mapstructure:"etcd"`
-	Nats    nats   `mapstructure:"nats"`
-	Signal  signal `mapstructure:"signal"`
+	Global  global  `mapstructure:"global"`
+	Etcd    etcd    `mapstructure:"etcd"`
+	Nats    nats    `mapstructure:"nats"`
+	Signal  signal  `mapstructure:"signal"`
+	Auth    auth    `mapstructure:"auth"`
+	Room    room    `mapstructure:"room"`
+	Admin   admin   `mapstructure:"admin"`
+	Webhook webhook `mapstructure:"webhook"`
 	CfgFile string
 }
 
@@ -0,0 +1,196 @@
+package src
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"server/pkg/proto"
+	"server/pkg/util"
+	"server/server/biz/conf"
+	"strings"
+
+	"github.com/zhuanxin-sz/go-protoo/logger"
+)
+
+const (
+	// adminFrom 管理接口操作的来源
+	adminFrom = "admin"
+)
+
+// initAdmin 注册管理接口,没有配置token时不启用
+func initAdmin() {
+	if conf.Admin.Token == "" {
+		return
+	}
+	http.HandleFunc("/admin/rooms", adminAuth(http.MethodGet, adminRooms))
+	http.HandleFunc("/admin/peers", adminAuth(http.MethodGet, adminPeers))
+	http.HandleFunc("/admin/users", adminAuth(http.MethodGet, adminUsers))
+	http.HandleFunc("/admin/pubs", adminAuth(http.MethodGet, adminPubs))
+	http.HandleFunc("/admin/kick", adminAuth(http.MethodPost, adminKick))
+	http.HandleFunc("/admin/close", adminAuth(http.MethodPost, adminClose))
+	http.HandleFunc("/admin/notify", adminAuth(http.MethodPost, adminNotify))
+	logger.Debugf("Start biz admin on %s/admin", conf.Global.Pprof)
+}
+
+// adminAuth 校验请求方法和token
+func adminAuth(method string, fn func(map[string]interface{}) (map[string]interface{}, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(conf.Admin.Token)) != 1 {
+			adminReply(w, http.StatusUnauthorized, nil, codeTokenErr)
+			return
+		}
+		if r.Method != method {
+			adminReply(w, http.StatusMethodNotAllowed, nil, codeUnknownErr)
+			return
+		}
+
+		// GET参数在url中, POST参数为json
+		msg := make(map[string]interface{})
+		if method == http.MethodGet {
+			for key := range r.URL.Query() {
+				msg[key] = r.URL.Query().Get(key)
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			adminReply(w, http.StatusBadRequest, nil, codeUnknownErr)
+			return
+		}
+
+		data, code := fn(msg)
+		if code != codeOK {
+			adminReply(w, http.StatusBadRequest, nil, code)
+			return
+		}
+		adminReply(w, http.StatusOK, data, codeOK)
+	}
+}
+
+// adminReply 回复json,格式和信令回复一致
+func adminReply(w http.ResponseWriter, status int, data map[string]interface{}, code int) {
+	var resp map[string]interface{}
+	if code == codeOK {
+		if data == nil {
+			data = emptyMap
+		}
+		resp = util.Map("ok", true, "data", data)
+	} else {
+		resp = util.Map("ok", false, "errorCode", code, "errorReason", codeStr(code))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GET /admin/rooms 本节点的房间
+func adminRooms(msg map[string]interface{}) (map[string]interface{}, int) {
+	list := make([]interface{}, 0)
+	for rid, room := range rooms.Snapshot() {
+		list = append(list, util.Map("rid", rid, "peers", len(room.GetUids())))
+	}
+	return util.Map("rooms", list), codeOK
+}
+
+// GET /admin/peers?rid=$rid 本节点房间的peer,没有rid时返回所有房间
+func adminPeers(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	list := make([]interface{}, 0)
+	for id, room := range rooms.Snapshot() {
+		if rid != "" && rid != id {
+			continue
+		}
+		for uid, peer := range room.Snapshot() {
+			list = append(list, util.Map("rid", id, "uid", uid, "role", peer.Role(id), "rooms", peer.RIDs(), "lastrecv", peer.LastRecv().Unix()))
+		}
+	}
+	return util.Map("peers", list), codeOK
+}
+
+// GET /admin/users?rid=$rid 集群中房间的用户
+func adminUsers(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	if rid == "" {
+		return nil, codeRIDErr
+	}
+	ok, users := FindRoomUsers(rid, "")
+	if !ok {
+		return nil, codeIslbRpcErr
+	}
+	return util.Map("users", users), codeOK
+}
+
+// GET /admin/pubs?rid=$rid 集群中房间的流
+func adminPubs(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	if rid == "" {
+		return nil, codeRIDErr
+	}
+	ok, pubs := FindRoomPubs(rid, "")
+	if !ok {
+		return nil, codeIslbRpcErr
+	}
+	return util.Map("pubs", pubs), codeOK
+}
+
+// POST /admin/kick {"rid", "uid", "reason", "block"} 踢出用户
+func adminKick(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	uid := util.Val(msg, "uid")
+	if rid == "" {
+		return nil, codeRIDErr
+	}
+	if uid == "" {
+		return nil, codeUIDErr
+	}
+	block, _ := msg["block"].(float64)
+	err := KickUser(rid, uid, util.Val(msg, "reason"), block, adminFrom)
+	if err != nil {
+		logger.Errorf("biz.adminKick rid=%s uid=%s err:%s", rid, uid, err.Reason)
+		return nil, codeUIDErr
+	}
+	return emptyMap, codeOK
+}
+
+// POST /admin/close {"rid", "reason"} 踢出房间所有用户
+func adminClose(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	if rid == "" {
+		return nil, codeRIDErr
+	}
+	reason := util.Val(msg, "reason")
+	ok, users := FindRoomUsers(rid, "")
+	if !ok {
+		return nil, codeIslbRpcErr
+	}
+
+	kicked := 0
+	for _, item := range users {
+		user, _ := item.(map[string]interface{})
+		uid := util.Val(user, "uid")
+		err := KickUser(rid, uid, reason, 0, adminFrom)
+		if err != nil {
+			logger.Errorf("biz.adminClose rid=%s uid=%s err:%s", rid, uid, err.Reason)
+			continue
+		}
+		kicked++
+	}
+
+	// islb中没有数据的本地peer
+	room := rooms.GetRoom(rid)
+	if room != nil {
+		for _, uid := range room.GetUids() {
+			KickPeer(rid, uid, reason)
+			kicked++
+		}
+	}
+	return util.Map("kicked", kicked), codeOK
+}
+
+// POST /admin/notify {"rid", "data"} 发送系统通知给房间所有人
+func adminNotify(msg map[string]interface{}) (map[string]interface{}, int) {
+	rid := util.Val(msg, "rid")
+	if rid == "" {
+		return nil, codeRIDErr
+	}
+	SendNotifyByUid(rid, "", proto.BizToClientSystem, util.Map("rid", rid, "data", msg["data"]))
+	return emptyMap, codeOK
+}
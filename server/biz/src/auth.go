@@ -0,0 +1,66 @@
+package src
+
+import (
+	"errors"
+	"server/server/biz/conf"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims token数据
+type Claims struct {
+	Uid  string `json:"uid"`
+	Rid  string `json:"rid"`
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+var errTokenExpired = errors.New("token expired")
+
+// authEnable 配置secret后才校验token
+func authEnable() bool {
+	return conf.Auth.Secret != ""
+}
+
+// ParseToken 校验token并返回token数据
+func ParseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("token signing method invalid")
+		}
+		return []byte(conf.Auth.Secret), nil
+	})
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, errTokenExpired
+		}
+		return nil, err
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token exp not found")
+	}
+	return claims, nil
+}
+
+// checkToken 校验join携带的token, 成功返回token数据
+func checkToken(msg map[string]interface{}, rid, uid string) (*Claims, int) {
+	token, _ := msg["token"].(string)
+	if token == "" {
+		return nil, codeTokenErr
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		if err == errTokenExpired {
+			return nil, codeTokenExpired
+		}
+		return nil, codeTokenErr
+	}
+
+	if claims.Uid != uid || claims.Rid != rid {
+		return nil, codeTokenErr
+	}
+	return claims, codeOK
+}
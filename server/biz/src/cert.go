@@ -0,0 +1,96 @@
+package src
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zhuanxin-sz/go-protoo/logger"
+)
+
+// CertLoader 证书加载对象,证书文件变化后自动重新加载
+type CertLoader struct {
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	certTime  time.Time
+	keyTime   time.Time
+	certMutex sync.RWMutex
+}
+
+// NewCertLoader 新建CertLoader对象
+func NewCertLoader(certFile, keyFile string) (*CertLoader, error) {
+	loader := &CertLoader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// Load 文件有变化时重新加载证书
+func (loader *CertLoader) Load() error {
+	certInfo, err := os.Stat(loader.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(loader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	loader.certMutex.RLock()
+	same := loader.cert != nil && certInfo.ModTime().Equal(loader.certTime) && keyInfo.ModTime().Equal(loader.keyTime)
+	loader.certMutex.RUnlock()
+	if same {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(loader.certFile, loader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	loader.certMutex.Lock()
+	defer loader.certMutex.Unlock()
+	loader.cert = &cert
+	loader.certTime = certInfo.ModTime()
+	loader.keyTime = keyInfo.ModTime()
+	logger.Infof("biz load cert=%s key=%s", loader.certFile, loader.keyFile)
+	return nil
+}
+
+// Watch 定时检查证书文件
+func (loader *CertLoader) Watch(cycle time.Duration) {
+	t := time.NewTicker(cycle)
+	defer t.Stop()
+	for range t.C {
+		err := loader.Load()
+		if err != nil {
+			logger.Errorf("biz reload cert err=%v", err)
+		}
+	}
+}
+
+// GetCertificate tls握手时获取当前证书
+func (loader *CertLoader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	loader.certMutex.RLock()
+	defer loader.certMutex.RUnlock()
+	if loader.cert == nil {
+		return nil, errors.New("cert not loaded")
+	}
+	return loader.cert, nil
+}
+
+// TLSConfig 生成tls配置
+func (loader *CertLoader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.GetCertificate,
+	}
+}
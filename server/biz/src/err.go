@@ -20,24 +20,52 @@ const (
 	codeSfuRpcErr
 	codeIslbRpcErr
 	codeUnknownErr
+	codeTokenErr
+	codeTokenExpired
+	codePermissionErr
+	codeRoleErr
+	codeTimeoutErr
+	codeClosedErr
+	codeKeyErr
+	codeVersionErr
+	codeInfoErr
+	codeBlockedErr
+	codeKindErr
+	codeRoomFullErr
+	codePubFullErr
+	codeLayerErr
 )
 
 var codeErr = map[int]string{
-	codeOK:         "OK",
-	codeUIDErr:     "uid not found",
-	codeRIDErr:     "rid not found",
-	codeMIDErr:     "mid not found",
-	codeSIDErr:     "sid not found",
-	codeJsepErr:    "jsep not found",
-	codeSdpErr:     "sdp not found",
-	codeMinfoErr:   "minfo not found",
-	codePubErr:     "pub not found",
-	codeSubErr:     "sub not found",
-	codeSfuErr:     "sfu not found",
-	codeIslbErr:    "islb not found",
-	codeSfuRpcErr:  "sfu rpc not found",
-	codeIslbRpcErr: "islb rpc not found",
-	codeUnknownErr: "unknown error",
+	codeOK:            "OK",
+	codeUIDErr:        "uid not found",
+	codeRIDErr:        "rid not found",
+	codeMIDErr:        "mid not found",
+	codeSIDErr:        "sid not found",
+	codeJsepErr:       "jsep not found",
+	codeSdpErr:        "sdp not found",
+	codeMinfoErr:      "minfo not found",
+	codePubErr:        "pub not found",
+	codeSubErr:        "sub not found",
+	codeSfuErr:        "sfu not found",
+	codeIslbErr:       "islb not found",
+	codeSfuRpcErr:     "sfu rpc not found",
+	codeIslbRpcErr:    "islb rpc not found",
+	codeUnknownErr:    "unknown error",
+	codeTokenErr:      "token invalid",
+	codeTokenExpired:  "token expired",
+	codePermissionErr: "permission denied",
+	codeRoleErr:       "role invalid",
+	codeTimeoutErr:    "request timeout",
+	codeClosedErr:     "peer closed",
+	codeKeyErr:        "key not found",
+	codeVersionErr:    "state version conflict",
+	codeInfoErr:       "info invalid or too large",
+	codeBlockedErr:    "uid blocked",
+	codeKindErr:       "kind invalid",
+	codeRoomFullErr:   "room is full",
+	codePubFullErr:    "room publishers is full",
+	codeLayerErr:      "layer invalid",
 }
 
 func codeStr(code int) string {
@@ -68,6 +96,9 @@ func invalid(msg map[string]interface{}, key string, reject RejectFunc) bool {
 		case "sdp":
 			reject(codeSdpErr, codeStr(codeSdpErr))
 			return true
+		case "key":
+			reject(codeKeyErr, codeStr(codeKeyErr))
+			return true
 		}
 	}
 	return false
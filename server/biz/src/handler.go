@@ -3,13 +3,34 @@ package src
 import (
 	"server/pkg/proto"
 	"server/pkg/util"
+	"server/server/biz/conf"
 
 	"github.com/zhuanxin-sz/go-protoo/logger"
-	nprotoo "github.com/zhuanxin-sz/nats-protoo"
+)
+
+const (
+	// defaultMaxInfo 用户信息默认最大长度
+	defaultMaxInfo = 1024
 )
 
 // handlerWebsocket 信令处理
 func handlerWebsocket(method string, peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	// 统计请求数量和耗时
+	accept, reject = observeRequest(method, accept, reject)
+
+	// 判断是否在房间,一个连接可以加入多个房间
+	rid := util.Val(msg, "rid")
+	if method != proto.ClientToBizJoin && rid != "" && !peer.InRoom(rid) {
+		reject(codeRIDErr, codeStr(codeRIDErr))
+		return
+	}
+
+	// 判断权限
+	if !checkPermission(peer, rid, method) {
+		reject(codePermissionErr, codeStr(codePermissionErr))
+		return
+	}
+
 	switch method {
 	case proto.ClientToBizJoin:
 		join(peer, msg, accept, reject)
@@ -31,6 +52,28 @@ func handlerWebsocket(method string, peer *Peer, msg map[string]interface{}, acc
 		getusers(peer, msg, accept, reject)
 	case proto.ClientToBizGetRoomPubs:
 		getpubs(peer, msg, accept, reject)
+	case proto.ClientToBizSetRole:
+		setrole(peer, msg, accept, reject)
+	case proto.ClientToBizMessage:
+		message(peer, msg, accept, reject)
+	case proto.ClientToBizSetState:
+		setstate(peer, msg, accept, reject)
+	case proto.ClientToBizGetState:
+		getstate(peer, msg, accept, reject)
+	case proto.ClientToBizDelState:
+		delstate(peer, msg, accept, reject)
+	case proto.ClientToBizUpdateInfo:
+		updateinfo(peer, msg, accept, reject)
+	case proto.ClientToBizKick:
+		kick(peer, msg, accept, reject)
+	case proto.ClientToBizStreamUpdate:
+		streamupdate(peer, msg, accept, reject)
+	case proto.ClientToBizMute:
+		mute(peer, msg, accept, reject)
+	case proto.ClientToBizHistory:
+		history(peer, msg, accept, reject)
+	case proto.ClientToBizSetLayer:
+		setlayer(peer, msg, accept, reject)
 	default:
 		DefaultReject(codeUnknownErr, codeStr(codeUnknownErr))
 	}
@@ -43,6 +86,10 @@ func handlerWebsocket(method string, peer *Peer, msg map[string]interface{}, acc
   "data":{
     "rid":"room"
 	"uid":"123456"
+	"token":"$jwt"
+	"info":{"name":"alice"}
+	"resume":"$token"
+	"history":20
   }
 */
 // 用户加入房间
@@ -57,7 +104,42 @@ func join(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject Reje
 
 	uid := util.Val(msg, "uid")
 	rid := util.Val(msg, "rid")
-	peer.id = uid
+
+	// 一个连接只能使用一个uid
+	if peer.ID() != "" && peer.ID() != uid {
+		reject(codeUIDErr, codeStr(codeUIDErr))
+		return
+	}
+
+	// 校验用户信息
+	info, ok := checkInfo(msg)
+	if !ok {
+		reject(codeInfoErr, codeStr(codeInfoErr))
+		return
+	}
+
+	// 校验token
+	role := defaultRole(rid)
+	var claims *Claims
+	if authEnable() {
+		var code int
+		claims, code = checkToken(msg, rid, uid)
+		if code != codeOK {
+			reject(code, codeStr(code))
+			return
+		}
+		if roleValid(claims.Role) {
+			role = claims.Role
+		}
+	}
+
+	// 断线重连,恢复之前的peer和流
+	token := util.Val(msg, "resume")
+	if resumeEnable() && token != "" && resumePeer(peer, token, rid, uid, accept) {
+		return
+	}
+
+	peer.SetID(uid)
 
 	// 获取islb服务器RPC句柄
 	islbRpc := GetRPCHandlerByServiceName("islb")
@@ -66,71 +148,83 @@ func join(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject Reje
 		return
 	}
 
+	// 查询uid是否被禁止加入
+	// resp = "rid", rid, "uid", uid, "blocked", blocked
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetBlock, util.Map("rid", rid, "uid", uid))
+	if err == nil && resp["blocked"] == true {
+		reject(codeBlockedErr, codeStr(codeBlockedErr))
+		return
+	}
+
 	// 查询uid是否在房间中
 	// resp = "rid", rid, "uid", uid, "bizid", bizid
-	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	resp, err = islbRpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	relogin := false
 	if err == nil {
 		// uid已经存在，先删除
 		bizid := resp["bizid"].(string)
 		if bizid != node.NodeInfo().Nid {
-			// 不在当前节点,通知其他节点关闭
+			// 不在当前节点,通知其他节点关闭,两边都不回调离开和加入事件
+			relogin = true
 			rpcBiz := rpcs[bizid]
 			if rpcBiz != nil {
-				rpcBiz.SyncRequest(proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid))
+				rpcBiz.SyncRequest(proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid, "relogin", true))
 			}
-		} else {
+		} else if FindLocalPeer(rid, uid) != peer {
 			// 在当前节点
+			relogin = true
+			ReloginPeer(rid, uid)
+		}
+	}
 
-			// 删除数据库流
-			// resp = "rmPubs", rmPubs
-			// pub = "rid", rid, "uid", uid, "mid", mid
-			resp, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-			if err == nil {
-				rmPubs, ok := resp["rmPubs"].([]interface{})
-				if ok {
-					SendNotifysByUid(rid, uid, proto.BizToBizOnStreamRemove, rmPubs)
-				}
-			} else {
-				logger.Errorf("biz.join request islb streamRemove err:%s", err.Reason)
-			}
-
-			// 删除数据库人
-			// resp = util.Map("rid", rid, "uid", uid)
-			_, err = islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
-			if err != nil {
-				logger.Errorf("biz.join request islb clientLeave err:%s", err.Reason)
-			}
-
-			// 发送广播给所有人
-			SendNotifyByUid(rid, uid, proto.BizToBizOnLeave, resp)
-			// 通知本地对象
-			//rooms.NotifyWithUid(rid, uid, proto.BizToClientOnKick, resp)
-			// 删除本地对象
-			room := rooms.GetRoom(rid)
-			if room != nil {
-				room.DelPeer(uid)
-			}
+	// 写数据库,人数超过限制时失败
+	// resp = "rid", rid, "uid", uid, "bizid", bizid, "info", info, "peers", peers
+	maxPeers, _ := roomLimit(rid)
+	data := util.Map("rid", rid, "uid", uid, "bizid", node.NodeInfo().Nid, "maxpeers", maxPeers)
+	if info != nil {
+		data["info"] = info
+	}
+	resp, err = islbRpc.SyncRequest(proto.BizToIslbOnJoin, data)
+	if err != nil {
+		if err.Code == 415 {
+			reject(codeRoomFullErr, codeStr(codeRoomFullErr))
+		} else {
+			reject(err.Code, err.Reason)
 		}
+		return
 	}
 
 	// 重新加入房间
 	room := rooms.AddRoom(rid)
 	room.AddPeer(peer)
-
-	// 写数据库
-	// resp = "rid", rid, "uid", uid, "bizid", bizid
-	resp, err = islbRpc.SyncRequest(proto.BizToIslbOnJoin, util.Map("rid", rid, "uid", uid, "bizid", node.NodeInfo().Nid))
-	if err != nil {
-		reject(err.Code, err.Reason)
-		return
+	peer.SetRole(rid, role)
+	if claims != nil {
+		peer.SetClaims(rid, claims)
 	}
 
 	// 广播通知房间其他人
-	SendNotifyByUid(rid, uid, proto.BizToBizOnJoin, resp)
+	resp["role"] = role
+	if relogin {
+		sendNotify(rid, uid, proto.BizToBizOnJoin, resp)
+	} else {
+		SendNotifyByUid(rid, uid, proto.BizToBizOnJoin, resp)
+	}
 
 	_, users := FindRoomUsers(rid, uid)
 	_, pubs := FindRoomPubs(rid, uid)
-	result := util.Map("users", users, "pubs", pubs)
+	_, state := FindRoomState(rid)
+	result := util.Map("users", users, "pubs", pubs, "state", state, "role", role)
+	// 最近的广播历史
+	if limit := util.InterfaceToInt(msg["history"]); limit > 0 {
+		// resp = "rid", rid, "messages", messages
+		resp, err := islbRpc.SyncRequest(proto.BizToIslbGetHistory, util.Map("rid", rid, "limit", limit))
+		if err == nil {
+			result["history"] = resp["messages"]
+		}
+	}
+	if resumeEnable() {
+		result["resume"] = peer.NewToken()
+	}
 	accept(result)
 }
 
@@ -171,19 +265,19 @@ func leave(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject Rej
 	}
 
 	// 删除数据库人
-	// resp = util.Map("rid", rid, "uid", uid)
+	// resp = util.Map("rid", rid, "uid", uid, "peers", peers)
 	resp, err = islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
-	if err != nil {
+	if err == nil {
+		// 发送广播给其他人
+		SendNotifyByUid(rid, uid, proto.BizToClientOnLeave, resp)
+	} else {
 		logger.Errorf("biz.leave request islb clientLeave err:%s", err.Reason)
 	}
-
-	// 发送广播给其他人
-	SendNotifyByUid(rid, uid, proto.BizToClientOnLeave, resp)
 	accept(emptyMap)
-	// 删除本地
+	// 删除本地,只离开这个房间,不关闭连接
 	room := rooms.GetRoom(rid)
-	if room != nil {
-		room.DelPeer(uid)
+	if room != nil && room.GetPeer(uid) == peer {
+		room.RemovePeer(uid)
 	}
 }
 
@@ -291,12 +385,18 @@ func publish(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject R
 		return
 	}
 
-	// 写数据库流
+	// 写数据库流,推流人数超过限制时删除sfu流
 	// resp = "rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", data["minfo"]
 	mid := util.Val(resp, "mid")
-	stream, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", minfo))
+	_, maxPubs := roomLimit(rid)
+	stream, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", minfo, "maxpubs", maxPubs))
 	if err != nil {
-		reject(err.Code, err.Reason)
+		sfuRpc.SyncRequest(proto.BizToSfuUnPublish, util.Map("rid", rid, "mid", mid))
+		if err.Code == 416 {
+			reject(codePubFullErr, codeStr(codePubFullErr))
+		} else {
+			reject(err.Code, err.Reason)
+		}
 		return
 	}
 
@@ -332,7 +432,7 @@ func unpublish(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject
 	mid := util.Val(msg, "mid")
 
 	// 获取sfu RPC句柄
-	var sfuRpc *nprotoo.Requestor
+	var sfuRpc *Requestor
 	sfuid := util.Val(msg, "sfuid")
 	if sfuid != "" {
 		sfuRpc = GetRPCHandlerByNodeID(sfuid)
@@ -409,7 +509,7 @@ func subscribe(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject
 	}
 
 	// 获取sfu RPC句柄
-	var sfuRpc *nprotoo.Requestor
+	var sfuRpc *Requestor
 	sfuid := util.Val(msg, "sfuid")
 	if sfuid != "" {
 		sfuRpc = GetRPCHandlerByNodeID(sfuid)
@@ -477,7 +577,7 @@ func unsubscribe(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reje
 	sid := util.Val(msg, "sid")
 
 	// 获取sfu RPC句柄
-	var sfuRpc *nprotoo.Requestor
+	var sfuRpc *Requestor
 	sfuid := util.Val(msg, "sfuid")
 	if sfuid != "" {
 		sfuRpc = GetRPCHandlerByNodeID(sfuid)
@@ -499,6 +599,64 @@ func unsubscribe(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reje
 	accept(emptyMap)
 }
 
+/*
+  "request":true
+  "id":3764139
+  "method":"setlayer"
+  "data":{
+    "rid": "room",
+    "mid": "64236c21-21e8-4a3d-9f80-c767d1e1d67f#ABCDEF"
+    "sid": "64236c21-21e8-4a3d-9f80-c767d1e1d67f#ABCDEF"
+    "layer": 0,
+	"sfuid":"shenzhen-sfu-1", (可选)
+  }
+*/
+// setlayer 选择订阅流的simulcast层,0为最低层
+func setlayer(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) || invalid(msg, "sid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+	mid := util.Val(msg, "mid")
+	sid := util.Val(msg, "sid")
+	// 只能修改自己的订阅
+	if proto.GetUIDFromMID(sid) != peer.ID() {
+		reject(codeSIDErr, codeStr(codeSIDErr))
+		return
+	}
+	if msg["layer"] == nil {
+		reject(codeLayerErr, codeStr(codeLayerErr))
+		return
+	}
+	layer := util.InterfaceToInt(msg["layer"])
+	if layer < 0 {
+		reject(codeLayerErr, codeStr(codeLayerErr))
+		return
+	}
+
+	// 获取sfu RPC句柄
+	var sfuRpc *Requestor
+	sfuid := util.Val(msg, "sfuid")
+	if sfuid != "" {
+		sfuRpc = GetRPCHandlerByNodeID(sfuid)
+	} else {
+		sfuRpc = GetSFURPCHandlerByMID(rid, mid)
+	}
+	if sfuRpc == nil {
+		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		return
+	}
+
+	// resp = "layer", layer
+	resp, err := sfuRpc.SyncRequest(proto.BizToSfuSetLayer, util.Map("rid", rid, "mid", mid, "sid", sid, "layer", layer))
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+	accept(resp)
+}
+
 /*
 	"request":true
 	"id":3764139
@@ -517,9 +675,26 @@ func broadcast(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject
 	uid := peer.ID()
 	rid := util.Val(msg, "rid")
 	data := util.Map("rid", rid, "uid", uid, "data", msg["data"])
+
+	// 保存广播历史,没有开启时不返回id
+	// resp = "rid", rid, "uid", uid, "id", id, "ts", ts
+	result := util.Map()
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc != nil {
+		resp, err := islbRpc.SyncRequest(proto.BizToIslbAddHistory, data)
+		if err != nil {
+			logger.Errorf("biz.broadcast request islb addHistory err:%s", err.Reason)
+		} else if resp["id"] != nil {
+			result["id"] = resp["id"]
+			result["ts"] = resp["ts"]
+			data["id"] = resp["id"]
+			data["ts"] = resp["ts"]
+		}
+	}
+
 	// 发送广播
 	SendNotifyByUid(rid, uid, proto.BizToClientBroadcast, data)
-	accept(emptyMap)
+	accept(result)
 }
 
 /*
@@ -567,3 +742,496 @@ func getpubs(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject R
 	result := util.Map("pubs", pubs)
 	accept(result)
 }
+
+/*
+	"request":true
+	"id":3764139
+	"method":"setrole"
+	"data":{
+		"rid": "room",
+		"uid": "123456",
+		"role": "viewer"
+	}
+*/
+// setrole 修改房间用户角色
+func setrole(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "uid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+	uid := util.Val(msg, "uid")
+	role := util.Val(msg, "role")
+	if !roleValid(role) {
+		reject(codeRoleErr, codeStr(codeRoleErr))
+		return
+	}
+
+	// 判断用户是否在线
+	if !GetBizExistByUID(rid, uid) {
+		reject(codeUIDErr, codeStr(codeUIDErr))
+		return
+	}
+
+	// 修改本地对象
+	peerRoleChange(rid, uid, role)
+
+	// 发送广播给所有人
+	data := util.Map("rid", rid, "uid", uid, "role", role, "from", peer.ID())
+	SendNotifyByUid(rid, peer.ID(), proto.BizToClientOnRoleChange, data)
+	accept(emptyMap)
+}
+
+/*
+	"request":true
+	"id":3764139
+	"method":"message"
+	"data":{
+		"rid": "room",
+		"to": "123456" 或 ["123456", "654321"],
+		"data": "$date"
+	}
+*/
+// message 发送消息给房间指定用户
+func message(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := util.Val(msg, "rid")
+
+	var to []string
+	if id, ok := msg["to"].(string); ok && id != "" {
+		to = append(to, id)
+	} else {
+		to = util.InterfaceToStringArray(msg["to"])
+	}
+	if len(to) == 0 {
+		reject(codeUIDErr, codeStr(codeUIDErr))
+		return
+	}
+
+	// 逐个投递
+	status := make(map[string]interface{})
+	for _, id := range to {
+		status[id] = SendMessageByUid(rid, uid, id, msg["data"])
+	}
+	accept(util.Map("status", status))
+}
+
+/*
+	"request":true
+	"id":3764139
+	"method":"setstate"
+	"data":{
+		"rid": "room",
+		"key": "slide",
+		"value": $value,
+		"version": 3, (可选,版本号一致才修改,0表示key不存在)
+	}
+*/
+// setstate 设置房间状态
+func setstate(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "key", reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := util.Val(msg, "rid")
+	key := util.Val(msg, "key")
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// 写数据库
+	// resp = "rid", rid, "key", key, "value", value, "version", version
+	data := util.Map("rid", rid, "key", key, "value", msg["value"])
+	if msg["version"] != nil {
+		data["version"] = msg["version"]
+	}
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbSetState, data)
+	if err != nil {
+		if err.Code == 414 {
+			reject(codeVersionErr, err.Reason)
+		} else {
+			reject(err.Code, err.Reason)
+		}
+		return
+	}
+
+	// 发送广播给其他人
+	notify := util.Map("rid", rid, "uid", uid, "key", key, "value", resp["value"], "version", resp["version"])
+	SendNotifyByUid(rid, uid, proto.BizToClientOnStateChange, notify)
+	accept(util.Map("key", key, "version", resp["version"]))
+}
+
+/*
+	"request":true
+	"id":3764139
+	"method":"getstate"
+	"data":{
+		"rid": "room",
+		"key": "slide", (可选,不传返回所有状态)
+	}
+*/
+// getstate 获取房间状态
+func getstate(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+	key := util.Val(msg, "key")
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// resp = "state", state
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetState, util.Map("rid", rid, "key", key))
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+	accept(util.Map("state", resp["state"]))
+}
+
+/*
+	"request":true
+	"id":3764139
+	"method":"delstate"
+	"data":{
+		"rid": "room",
+		"key": "slide",
+		"version": 3, (可选,版本号一致才删除)
+	}
+*/
+// delstate 删除房间状态
+func delstate(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "key", reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := util.Val(msg, "rid")
+	key := util.Val(msg, "key")
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// 删除数据库
+	// resp = "rid", rid, "key", key, "version", version
+	data := util.Map("rid", rid, "key", key)
+	if msg["version"] != nil {
+		data["version"] = msg["version"]
+	}
+	_, err := islbRpc.SyncRequest(proto.BizToIslbDelState, data)
+	if err != nil {
+		if err.Code == 414 {
+			reject(codeVersionErr, err.Reason)
+		} else {
+			reject(err.Code, err.Reason)
+		}
+		return
+	}
+
+	// 发送广播给其他人, value为空表示删除
+	notify := util.Map("rid", rid, "uid", uid, "key", key, "value", nil, "version", 0)
+	SendNotifyByUid(rid, uid, proto.BizToClientOnStateChange, notify)
+	accept(emptyMap)
+}
+
+/*
+  "request":true
+  "id":3764139
+  "method":"updateinfo"
+  "data":{
+    "rid":"room",
+    "info":{"name":"alice"}
+  }
+*/
+// updateinfo 修改用户信息
+func updateinfo(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := util.Val(msg, "rid")
+
+	info, ok := checkInfo(msg)
+	if !ok || info == nil {
+		reject(codeInfoErr, codeStr(codeInfoErr))
+		return
+	}
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// 写数据库
+	// resp = "rid", rid, "uid", uid, "info", info
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbUpdateInfo, util.Map("rid", rid, "uid", uid, "info", info))
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+
+	// 发送广播给其他人
+	SendNotifyByUid(rid, uid, proto.BizToClientOnUpdate, resp)
+	accept(emptyMap)
+}
+
+/*
+  "request":true
+  "id":3764139
+  "method":"kick"
+  "data":{
+    "rid":"room",
+    "uid":"123456",
+    "reason":"spam",
+    "block":600
+  }
+*/
+// kick 踢出房间用户,block秒内禁止再次加入
+func kick(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "uid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+	uid := util.Val(msg, "uid")
+	reason := util.Val(msg, "reason")
+	block, _ := msg["block"].(float64)
+
+	err := KickUser(rid, uid, reason, block, peer.ID())
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+	accept(emptyMap)
+}
+
+/*
+  "request":true
+  "id":3764139
+  "method":"streamupdate"
+  "data":{
+    "rid":"room",
+    "mid":"64236c21-21e8-4a3d-9f80-c767d1e1d67f#ABCDEF",
+    "minfo":{"audio":false,"video":true,"audiotype":0,"videotype":0}
+  }
+*/
+// streamupdate 修改流信息
+func streamupdate(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := util.Val(msg, "rid")
+	mid := util.Val(msg, "mid")
+
+	// 只能修改自己的流
+	if proto.GetUIDFromMID(mid) != uid {
+		reject(codeMIDErr, codeStr(codeMIDErr))
+		return
+	}
+
+	minfo, ok := msg["minfo"].(map[string]interface{})
+	if minfo == nil || !ok {
+		reject(codeMinfoErr, codeStr(codeMinfoErr))
+		return
+	}
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// 写数据库流
+	// resp = "rid", rid, "uid", uid, "mid", mid, "minfo", minfo
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamUpdate, util.Map("rid", rid, "uid", uid, "mid", mid, "minfo", minfo))
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+
+	// 发送广播给其他人
+	SendNotifyByUid(rid, uid, proto.BizToClientOnStreamUpdate, resp)
+	accept(emptyMap)
+}
+
+/*
+  "request":true
+  "id":3764139
+  "method":"mute"
+  "data":{
+    "rid":"room",
+    "uid":"123456",
+    "mid":"123456#ABCDEF",
+    "kind":"audio",
+    "mute":true,
+    "force":false
+  }
+*/
+// mute 请求用户静音,force为true时sfu同时停止转发
+func mute(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) || invalid(msg, "uid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+	uid := util.Val(msg, "uid")
+	mid := util.Val(msg, "mid")
+	kind := util.Val(msg, "kind")
+	if kind == "" {
+		kind = "audio"
+	}
+	if kind != "audio" && kind != "video" {
+		reject(codeKindErr, codeStr(codeKindErr))
+		return
+	}
+	muted := true
+	if val, ok := msg["mute"].(bool); ok {
+		muted = val
+	}
+	force, _ := msg["force"].(bool)
+
+	// sfu停止转发
+	if force {
+		if invalid(msg, "mid", reject) {
+			return
+		}
+		if proto.GetUIDFromMID(mid) != uid {
+			reject(codeMIDErr, codeStr(codeMIDErr))
+			return
+		}
+		sfuRpc := GetSFURPCHandlerByMID(rid, mid)
+		if sfuRpc == nil {
+			reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+			return
+		}
+		_, err := sfuRpc.SyncRequest(proto.BizToSfuMute, util.Map("rid", rid, "mid", mid, "kind", kind, "mute", muted))
+		if err != nil {
+			reject(err.Code, err.Reason)
+			return
+		}
+	}
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// 查询用户所在biz节点
+	// resp = "rid", rid, "uid", uid, "bizid", bizid
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	if err != nil {
+		reject(codeUIDErr, codeStr(codeUIDErr))
+		return
+	}
+
+	// 请求客户端静音
+	data := util.Map("rid", rid, "uid", uid, "mid", mid, "kind", kind, "mute", muted, "from", peer.ID())
+	bizid := util.Val(resp, "bizid")
+	if bizid == node.NodeInfo().Nid {
+		// 在当前节点
+		target := FindLocalPeer(rid, uid)
+		if target == nil {
+			reject(codeUIDErr, codeStr(codeUIDErr))
+			return
+		}
+		target.RequestWithTimeout(proto.BizToClientMute, data, muteTimeout, func(result map[string]interface{}) {
+			accept(emptyMap)
+		}, reject)
+		return
+	}
+
+	// 在其他节点
+	rpcBiz := GetRPCHandlerByNodeID(bizid)
+	if rpcBiz == nil {
+		reject(codeUIDErr, codeStr(codeUIDErr))
+		return
+	}
+	_, err = rpcBiz.SyncRequest(proto.BizToBizMute, data)
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+	accept(emptyMap)
+}
+
+/*
+  "request":true
+  "id":3764139
+  "method":"history"
+  "data":{
+    "rid":"room",
+    "before":120,
+    "after":0,
+    "limit":50
+  }
+*/
+// history 获取房间广播历史
+func history(peer *Peer, msg map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	if invalid(msg, "rid", reject) {
+		return
+	}
+
+	rid := util.Val(msg, "rid")
+
+	// 获取islb RPC句柄
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		return
+	}
+
+	// resp = "rid", rid, "messages", messages
+	data := util.Map("rid", rid, "before", msg["before"], "after", msg["after"], "limit", msg["limit"])
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetHistory, data)
+	if err != nil {
+		reject(err.Code, err.Reason)
+		return
+	}
+	accept(util.Map("messages", resp["messages"]))
+}
+
+// checkInfo 校验用户信息,info必须是对象且不超过配置长度,没有info时返回nil
+func checkInfo(msg map[string]interface{}) (map[string]interface{}, bool) {
+	if msg["info"] == nil {
+		return nil, true
+	}
+	info, ok := msg["info"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	maxInfo := conf.Room.MaxInfo
+	if maxInfo <= 0 {
+		maxInfo = defaultMaxInfo
+	}
+	if len(util.Marshal(info)) > maxInfo {
+		return nil, false
+	}
+	return info, true
+}
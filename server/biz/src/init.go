@@ -1,20 +1,31 @@
 package src
 
 import (
+	"crypto/tls"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"server/pkg/etcd"
 	"server/pkg/proto"
 	"server/pkg/util"
 	"server/server/biz/conf"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zhuanxin-sz/go-protoo/logger"
 	nprotoo "github.com/zhuanxin-sz/nats-protoo"
 )
 
 const (
 	statCycle = 10 * time.Second
+	certCycle = 60 * time.Second
+)
+
+// 消息投递状态
+const (
+	messageOK      = "ok"
+	messageOffline = "offline"
+	messageFailed  = "failed"
 )
 
 var (
@@ -23,7 +34,7 @@ var (
 	watch  *etcd.ServiceWatcher
 	nats   *nprotoo.NatsProtoo
 	caster *nprotoo.Broadcaster
-	rpcs   = make(map[string]*nprotoo.Requestor)
+	rpcs   = make(map[string]*Requestor)
 )
 
 // Start 启动服务
@@ -41,7 +52,9 @@ func Start() {
 	// 消息广播
 	caster = nats.NewBroadcaster(node.GetEventChannel())
 	// 启动tcp server
-	go StartTcp(conf.Signal.Host, uint16(conf.Signal.Port))
+	startSignal()
+	// 启动事件回调
+	startWebhook()
 	// 启动房间资源回收
 	go CheckRoom()
 	// 启动调试
@@ -63,8 +76,42 @@ func Stop() {
 	}
 }
 
+// startSignal 启动信令监听,配置证书后启用tls
+func startSignal() {
+	var config *tls.Config
+	if conf.Signal.Cert != "" && conf.Signal.Key != "" {
+		loader, err := NewCertLoader(conf.Signal.Cert, conf.Signal.Key)
+		if err != nil {
+			// 证书错误时退出,不能注册了节点却不提供服务
+			logger.Errorf("biz load cert err=%v", err)
+			Stop()
+			os.Exit(-1)
+		}
+		go loader.Watch(certCycle)
+		config = loader.TLSConfig()
+	}
+
+	if config == nil || conf.Signal.TlsPort == 0 {
+		// 只启动一个端口
+		go StartTcp(conf.Signal.Host, uint16(conf.Signal.Port), config)
+	} else {
+		// 同时启动明文和tls
+		if conf.Signal.Port != 0 {
+			go StartTcp(conf.Signal.Host, uint16(conf.Signal.Port), nil)
+		}
+		go StartTcp(conf.Signal.Host, uint16(conf.Signal.TlsPort), config)
+	}
+
+	// 启动websocket server
+	if conf.Signal.WsPort != 0 {
+		go StartWebsocket(conf.Signal.Host, uint16(conf.Signal.WsPort), config)
+	}
+}
+
 func debug() {
 	logger.Debugf("Start biz pprof on %s", conf.Global.Pprof)
+	initAdmin()
+	http.Handle("/metrics", promhttp.Handler())
 	http.ListenAndServe(conf.Global.Pprof, nil)
 }
 
@@ -86,7 +133,7 @@ func WatchServiceCallBack(state int32, n etcd.Node) {
 		_, found := rpcs[id]
 		if !found {
 			rpcID := etcd.GetRPCChannel(n)
-			rpcs[id] = nats.NewRequestor(rpcID)
+			rpcs[id] = NewRequestor(nats.NewRequestor(rpcID), n.Name)
 		}
 	} else if state == etcd.ServerDown {
 		delete(rpcs, n.Nid)
@@ -94,7 +141,7 @@ func WatchServiceCallBack(state int32, n etcd.Node) {
 }
 
 // GetRPCHandlerByServiceName 通过服务名获取RPC Handler
-func GetRPCHandlerByServiceName(name string) *nprotoo.Requestor {
+func GetRPCHandlerByServiceName(name string) *Requestor {
 	var tmp etcd.Node
 	var node *etcd.Node
 	services, find := watch.GetNodes(name)
@@ -115,7 +162,7 @@ func GetRPCHandlerByServiceName(name string) *nprotoo.Requestor {
 }
 
 // GetRPCHandlerByNodeID 获取指定id的获取RPC Handler
-func GetRPCHandlerByNodeID(nid string) *nprotoo.Requestor {
+func GetRPCHandlerByNodeID(nid string) *Requestor {
 	node, find := watch.GetNodeByID(nid)
 	if !find {
 		return nil
@@ -130,7 +177,7 @@ func GetRPCHandlerByNodeID(nid string) *nprotoo.Requestor {
 }
 
 // GetRPCHandlerByPayload 获取最低负载的RPC Handler和id
-func GetRPCHandlerByPayload(name string) (*nprotoo.Requestor, string) {
+func GetRPCHandlerByPayload(name string) (*Requestor, string) {
 	node, find := watch.GetNodeByPayload(node.NodeInfo().Ndc, name)
 	if !find {
 		return nil, ""
@@ -172,7 +219,7 @@ func GetBizExistByUID(rid, uid string) bool {
 }
 
 // GetSFURPCHandlerByMID 根据rid, mid获取sfu节点rpc句柄
-func GetSFURPCHandlerByMID(rid, mid string) *nprotoo.Requestor {
+func GetSFURPCHandlerByMID(rid, mid string) *Requestor {
 	islbRpc := GetRPCHandlerByServiceName("islb")
 	if islbRpc == nil {
 		logger.Errorf("GetSFURPCHandlerByMID can't get available islb node")
@@ -188,7 +235,7 @@ func GetSFURPCHandlerByMID(rid, mid string) *nprotoo.Requestor {
 
 	logger.Infof("GetSFURPCHandlerByMID resp ==> %v", resp)
 
-	var sfu *nprotoo.Requestor
+	var sfu *Requestor
 	sfuid := util.Val(resp, "sfuid")
 	if sfuid != "" {
 		sfu = GetRPCHandlerByNodeID(sfuid)
@@ -250,13 +297,38 @@ func FindRoomPubs(rid, uid string) (bool, []interface{}) {
 	return true, pubs
 }
 
+// FindRoomState 获取房间状态
+func FindRoomState(rid string) (bool, map[string]interface{}) {
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		logger.Errorf("FindRoomState can't get available islb node")
+		return false, nil
+	}
+
+	// resp = "state", state
+	// state = key, util.Map("value", value, "version", version)
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetState, util.Map("rid", rid))
+	if err != nil {
+		logger.Errorf(err.Reason)
+		return false, nil
+	}
+
+	state, ok := resp["state"].(map[string]interface{})
+	if !ok {
+		logger.Errorf("FindRoomState state is nil")
+		return false, nil
+	}
+	return true, state
+}
+
 // CheckRoom 检查所有的房间
 func CheckRoom() {
 	t := time.NewTicker(statCycle)
 	defer t.Stop()
 	for range t.C {
-		for rid, room := range rooms.GetRooms() {
-			for uid := range room.GetPeers() {
+		for rid, room := range rooms.Snapshot() {
+			// 只删除这个房间的peer,peer在其他房间时不关闭连接
+			for _, uid := range room.GetUids() {
 				exist := GetBizExistByUID(rid, uid)
 				if !exist {
 					// 获取islb RPC句柄
@@ -277,7 +349,7 @@ func CheckRoom() {
 						logger.Errorf("biz.checkRoom request islb streamRemove err:%s", err.Reason)
 					}
 					// 删除数据库人
-					// resp = "rid", rid, "uid", uid
+					// resp = "rid", rid, "uid", uid, "peers", peers
 					resp, err = islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
 					if err == nil {
 						SendNotifyByUid(rid, uid, proto.BizToClientOnLeave, resp)
@@ -289,7 +361,7 @@ func CheckRoom() {
 					logger.Debugf("room=%s del peer uid=%s", rid, uid)
 				}
 			}
-			if len(room.GetPeers()) == 0 {
+			if len(room.GetUids()) == 0 {
 				logger.Debugf("no peer in room=%s now", rid)
 				rooms.DelRoom(rid)
 			}
@@ -297,8 +369,216 @@ func CheckRoom() {
 	}
 }
 
+// KickUser 踢出房间用户,用户可以在任意biz节点,block秒内禁止再次加入
+func KickUser(rid, uid, reason string, block float64, from string) *nprotoo.Error {
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		return &nprotoo.Error{Code: codeIslbRpcErr, Reason: codeStr(codeIslbRpcErr)}
+	}
+
+	// 禁止再次加入
+	if block > 0 {
+		_, err := islbRpc.SyncRequest(proto.BizToIslbSetBlock, util.Map("rid", rid, "uid", uid, "ttl", block, "from", from))
+		if err != nil {
+			return err
+		}
+	}
+
+	// 查询用户所在biz节点
+	// resp = "rid", rid, "uid", uid, "bizid", bizid
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	if err != nil {
+		// 用户不在线时只封禁
+		if block > 0 {
+			return nil
+		}
+		return &nprotoo.Error{Code: codeUIDErr, Reason: codeStr(codeUIDErr)}
+	}
+
+	bizid := util.Val(resp, "bizid")
+	if bizid == node.NodeInfo().Nid {
+		// 在当前节点
+		KickPeer(rid, uid, reason)
+		return nil
+	}
+
+	// 在其他节点
+	rpcBiz := GetRPCHandlerByNodeID(bizid)
+	if rpcBiz == nil {
+		return &nprotoo.Error{Code: codeUIDErr, Reason: codeStr(codeUIDErr)}
+	}
+	_, err = rpcBiz.SyncRequest(proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid, "reason", reason))
+	return err
+}
+
+// KickPeer 踢出本节点用户,先通知原因,再删除sfu和islb中的流,最后关闭连接
+func KickPeer(rid, uid, reason string) {
+	NotifyLocalPeer(rid, uid, proto.BizToClientOnKick, util.Map("rid", rid, "uid", uid, "reason", reason))
+
+	// 删除数据库流和sfu流,再删除房间数据,关闭连接前会发送完peer-kick
+	removeStreams(rid, uid, true)
+	removePeer(rid, uid)
+}
+
+// ClearPeer 删除用户的流和房间数据并通知其他人
+func ClearPeer(rid, uid string) {
+	removeStreams(rid, uid, false)
+	removePeer(rid, uid)
+}
+
+// ReloginPeer 用户重复登录,删除老连接的数据并关闭连接,不通知被踢,不删除sfu流,不回调离开事件
+func ReloginPeer(rid, uid string) {
+	removeStreams(rid, uid, false)
+
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc != nil {
+		// 删除数据库人
+		// resp = "rid", rid, "uid", uid, "peers", peers
+		resp, err := islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
+		if err == nil {
+			// 重复登录不回调离开和加入事件,避免房间被误认为销毁又创建
+			sendNotify(rid, uid, proto.BizToClientOnLeave, resp)
+		} else {
+			logger.Errorf("biz.ReloginPeer request islb clientLeave err:%s", err.Reason)
+		}
+	} else {
+		logger.Errorf("biz.ReloginPeer can't get available islb node")
+	}
+
+	// 删除本地对象
+	room := rooms.GetRoom(rid)
+	if room != nil {
+		room.DelPeer(uid)
+	}
+}
+
+// removeStreams 删除用户在数据库中的流并通知其他人,unpublish为true时同时删除sfu流
+func removeStreams(rid, uid string, unpublish bool) {
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		logger.Errorf("biz.removeStreams can't get available islb node")
+		return
+	}
+
+	// 删除数据库流
+	// resp = "rmPubs", rmPubs
+	// pub = "rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
+	if err != nil {
+		logger.Errorf("biz.removeStreams request islb streamRemove err:%s", err.Reason)
+		return
+	}
+	rmPubs, ok := resp["rmPubs"].([]interface{})
+	if !ok {
+		return
+	}
+
+	// 删除sfu流
+	if unpublish {
+		for _, pub := range rmPubs {
+			data := pub.(map[string]interface{})
+			sfuRpc := GetRPCHandlerByNodeID(util.Val(data, "sfuid"))
+			if sfuRpc == nil {
+				continue
+			}
+			_, err := sfuRpc.SyncRequest(proto.BizToSfuUnPublish, util.Map("rid", rid, "mid", util.Val(data, "mid")))
+			if err != nil {
+				logger.Errorf("biz.removeStreams request sfu unpublish err:%s", err.Reason)
+			}
+		}
+	}
+	SendNotifysByUid(rid, uid, proto.BizToClientOnStreamRemove, rmPubs)
+}
+
+// removePeer 删除用户在数据库和本地的房间数据并通知其他人
+func removePeer(rid, uid string) {
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc != nil {
+		// 删除数据库人
+		// resp = "rid", rid, "uid", uid, "peers", peers
+		resp, err := islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
+		if err == nil {
+			SendNotifyByUid(rid, uid, proto.BizToClientOnLeave, resp)
+		} else {
+			logger.Errorf("biz.removePeer request islb clientLeave err:%s", err.Reason)
+		}
+	} else {
+		logger.Errorf("biz.removePeer can't get available islb node")
+	}
+
+	// 删除本地对象
+	room := rooms.GetRoom(rid)
+	if room != nil {
+		room.DelPeer(uid)
+		logger.Debugf("room=%s del peer uid=%s", rid, uid)
+	}
+}
+
+// NotifyLocalPeer 通知本节点指定用户,用户不在本节点返回false
+func NotifyLocalPeer(rid, uid, method string, msg map[string]interface{}) bool {
+	peer := FindLocalPeer(rid, uid)
+	if peer == nil {
+		return false
+	}
+	peer.Notify(method, msg)
+	return true
+}
+
+// FindLocalPeer 查找本节点房间内的用户
+func FindLocalPeer(rid, uid string) *Peer {
+	room := rooms.GetRoom(rid)
+	if room == nil {
+		return nil
+	}
+	return room.GetPeer(uid)
+}
+
+// SendMessageByUid 发送消息给指定用户,返回投递状态
+func SendMessageByUid(rid, uid, to string, data interface{}) string {
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	if islbRpc == nil {
+		logger.Errorf("SendMessageByUid can't get available islb node")
+		return messageFailed
+	}
+
+	// 查询用户所在biz节点
+	// resp = "rid", rid, "uid", uid, "bizid", bizid
+	resp, err := islbRpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", to))
+	if err != nil {
+		return messageOffline
+	}
+
+	bizid := util.Val(resp, "bizid")
+	if bizid == node.NodeInfo().Nid {
+		// 在当前节点
+		if NotifyLocalPeer(rid, to, proto.BizToClientMessage, util.Map("rid", rid, "uid", uid, "data", data)) {
+			return messageOK
+		}
+		return messageOffline
+	}
+
+	// 在其他节点
+	rpcBiz := GetRPCHandlerByNodeID(bizid)
+	if rpcBiz == nil {
+		return messageOffline
+	}
+	_, err = rpcBiz.SyncRequest(proto.BizToBizMessage, util.Map("rid", rid, "uid", uid, "to", to, "data", data))
+	if err != nil {
+		logger.Errorf("SendMessageByUid request biz=%s err:%s", bizid, err.Reason)
+		return messageFailed
+	}
+	return messageOK
+}
+
 // SendNotifyByUid 单发广播给其他人
 func SendNotifyByUid(rid, skipUid, method string, msg map[string]interface{}) {
+	sendNotify(rid, skipUid, method, msg)
+	// 只有发起通知的biz回调事件
+	webhookNotify(rid, skipUid, method, msg)
+}
+
+// sendNotify 单发广播给其他人,不回调事件
+func sendNotify(rid, skipUid, method string, msg map[string]interface{}) {
 	NotifyPeersWithoutID(rid, skipUid, method, msg)
 	caster.Say(method, msg)
 }
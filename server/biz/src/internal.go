@@ -10,6 +10,9 @@ import (
 	nprotoo "github.com/zhuanxin-sz/nats-protoo"
 )
 
+// muteTimeout 等待客户端回复静音请求的时间,必须小于rpc超时,否则静音成功时发起方收到rpc超时
+const muteTimeout = nprotoo.DefaultRequestTimeout / 2
+
 // 处理biz的rpc请求
 func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
 	go handleRPCRequest(request, accept, reject)
@@ -29,6 +32,10 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 	/* 处理和biz服务器通信 */
 	case proto.BizToBizOnKick:
 		result, err = peerKick(data)
+	case proto.BizToBizMessage:
+		result, err = peerMessage(data)
+	case proto.BizToBizMute:
+		result, err = peerMute(data)
 	}
 	if err != nil {
 		reject(err.Code, err.Reason)
@@ -38,48 +45,59 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 }
 
 /*
-	"method", proto.BizToBizOnKick, "rid", rid, "uid", uid
+	"method", proto.BizToBizOnKick, "rid", rid, "uid", uid, "reason", reason, "relogin", relogin
 */
-// 踢出房间
+// 踢出房间,relogin为true时是用户在其他节点重复登录
 func peerKick(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
 	rid := util.Val(data, "rid")
 	uid := util.Val(data, "uid")
+	reason := util.Val(data, "reason")
 
-	// 获取islb RPC句柄
-	islbRpc := GetRPCHandlerByServiceName("islb")
-	if islbRpc == nil {
-		return nil, &nprotoo.Error{Code: -1, Reason: "can't get available islb node"}
+	if data["relogin"] == true {
+		ReloginPeer(rid, uid)
+		return util.Map(), nil
 	}
+	KickPeer(rid, uid, reason)
+	return util.Map(), nil
+}
 
-	// 删除数据库流
-	// resp = "rmPubs", rmPubs
-	// pub = "rid", rid, "uid", uid, "mid", mid
-	resp, err := islbRpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-	if err == nil {
-		rmPubs, ok := resp["rmPubs"].([]interface{})
-		if ok {
-			SendNotifysByUid(rid, uid, proto.BizToBizOnStreamRemove, rmPubs)
-		}
-	} else {
-		logger.Errorf("biz.peerKick request islb streamRemove err:%s", err.Reason)
-	}
+/*
+	"method", proto.BizToBizMessage, "rid", rid, "uid", uid, "to", to, "data", data
+*/
+// 发送消息给本节点用户
+func peerMessage(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	to := util.Val(data, "to")
 
-	// 删除数据库人
-	// resp = util.Map("rid", rid, "uid", uid)
-	_, err = islbRpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
-	if err != nil {
-		logger.Errorf("biz.peerKick request islb clientLeave err:%s", err.Reason)
+	if !NotifyLocalPeer(rid, to, proto.BizToClientMessage, util.Map("rid", rid, "uid", uid, "data", data["data"])) {
+		return nil, &nprotoo.Error{Code: 404, Reason: fmt.Sprintf("can't find peer rid=%s uid=%s", rid, to)}
 	}
-	// 发送广播给所有人
-	SendNotifyByUid(rid, uid, proto.BizToBizOnLeave, resp)
+	return util.Map(), nil
+}
 
-	// 通知客户端
-	//NotifyPeerWithID(rid, uid, proto.BizToClientOnKick, util.Map("rid", rid, "uid", uid))
+/*
+	"method", proto.BizToBizMute, "rid", rid, "uid", uid, "mid", mid, "kind", kind, "mute", mute, "from", from
+*/
+// 请求本节点用户静音,等待客户端回复,超时时间小于rpc的超时时间
+func peerMute(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
 
-	// 删除本地对象
-	room := rooms.GetRoom(rid)
-	if room != nil {
-		room.DelPeer(uid)
+	peer := FindLocalPeer(rid, uid)
+	if peer == nil {
+		return nil, &nprotoo.Error{Code: 404, Reason: fmt.Sprintf("can't find peer rid=%s uid=%s", rid, uid)}
+	}
+
+	done := make(chan *nprotoo.Error, 1)
+	peer.RequestWithTimeout(proto.BizToClientMute, data, muteTimeout, func(result map[string]interface{}) {
+		done <- nil
+	}, func(code int, reason string) {
+		done <- &nprotoo.Error{Code: code, Reason: reason}
+	})
+	err := <-done
+	if err != nil {
+		return nil, err
 	}
 	return util.Map(), nil
 }
@@ -110,6 +128,22 @@ func handleBroadcast(msg map[string]interface{}, subj string) {
 	case proto.BizToBizBroadcast:
 		/* "method", proto.BizToBizBroadcast, "rid", rid, "uid", uid, "data", data */
 		NotifyPeersWithoutID(rid, uid, proto.BizToClientBroadcast, data)
+	case proto.BizToBizOnStateChange:
+		/* "method", proto.BizToBizOnStateChange, "rid", rid, "uid", uid, "key", key, "value", value, "version", version */
+		NotifyPeersWithoutID(rid, uid, proto.BizToClientOnStateChange, data)
+	case proto.BizToBizSystem:
+		/* "method", proto.BizToBizSystem, "rid", rid, "data", data */
+		NotifyPeersWithoutID(rid, uid, proto.BizToClientSystem, data)
+	case proto.BizToBizOnStreamUpdate:
+		/* "method", proto.BizToBizOnStreamUpdate, "rid", rid, "uid", uid, "mid", mid, "minfo", minfo */
+		NotifyPeersWithoutID(rid, uid, proto.BizToClientOnStreamUpdate, data)
+	case proto.BizToBizOnUpdate:
+		/* "method", proto.BizToBizOnUpdate, "rid", rid, "uid", uid, "info", info */
+		NotifyPeersWithoutID(rid, uid, proto.BizToClientOnUpdate, data)
+	case proto.BizToBizOnRoleChange:
+		/* "method", proto.BizToBizOnRoleChange, "rid", rid, "uid", uid, "role", role, "from", from */
+		peerRoleChange(rid, uid, util.Val(data, "role"))
+		NotifyPeersWithoutID(rid, util.Val(data, "from"), proto.BizToClientOnRoleChange, data)
 	case proto.SfuToBizOnStreamRemove:
 		mid := util.Val(data, "mid")
 		sfuRemoveStream(rid, uid, mid)
@@ -137,6 +171,18 @@ func sfuRemoveStream(rid, uid, mid string) {
 	}
 }
 
+// 修改本地用户角色
+func peerRoleChange(rid, uid, role string) {
+	room := rooms.GetRoom(rid)
+	if room == nil {
+		return
+	}
+	peer := room.GetPeer(uid)
+	if peer != nil {
+		peer.SetRole(rid, role)
+	}
+}
+
 // NotifyPeersWithoutID 通知房间其他人
 func NotifyPeersWithoutID(rid, uid, method string, msg map[string]interface{}) {
 	rooms.NotifyWithoutUid(rid, uid, method, msg)
@@ -0,0 +1,22 @@
+package src
+
+import (
+	"server/server/biz/conf"
+	"strings"
+)
+
+// roomLimit 房间最大人数和最大推流人数, 0表示不限制
+func roomLimit(rid string) (int, int) {
+	maxPeers := conf.Room.MaxPeers
+	maxPubs := conf.Room.MaxPubs
+	// 配置中的key都是小写
+	if l, ok := conf.Room.Limits[strings.ToLower(rid)]; ok {
+		if l.MaxPeers > 0 {
+			maxPeers = l.MaxPeers
+		}
+		if l.MaxPubs > 0 {
+			maxPubs = l.MaxPubs
+		}
+	}
+	return maxPeers, maxPubs
+}
@@ -0,0 +1,120 @@
+package src
+
+import (
+	"server/pkg/proto"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	nprotoo "github.com/zhuanxin-sz/nats-protoo"
+)
+
+var (
+	// metricConns 信令连接数量
+	metricConns = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "biz_peers",
+		Help: "Number of connected signal peers.",
+	})
+	// metricRooms 本节点房间数量
+	metricRooms = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "biz_rooms",
+		Help: "Number of rooms on this node.",
+	}, func() float64 {
+		if rooms == nil {
+			return 0
+		}
+		return float64(rooms.Count())
+	})
+	// metricRequests 客户端请求数量, result = ok/error
+	metricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "biz_requests_total",
+		Help: "Client requests handled, by method and result.",
+	}, []string{"method", "result"})
+	// metricRequestSeconds 客户端请求耗时
+	metricRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "biz_request_duration_seconds",
+		Help:    "Client request latency, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	// metricRPCSeconds 请求其他服务的耗时
+	metricRPCSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "biz_rpc_duration_seconds",
+		Help:    "RPC latency to other services, by service and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method"})
+	// metricRPCErrors 请求其他服务失败数量
+	metricRPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "biz_rpc_errors_total",
+		Help: "RPC errors to other services, by service and method.",
+	}, []string{"service", "method"})
+)
+
+// requestMethods 客户端方法,其他方法统计为unknown,避免标签无限增长
+var requestMethods = map[string]bool{
+	proto.ClientToBizJoin:         true,
+	proto.ClientToBizLeave:        true,
+	proto.ClientToBizKeepAlive:    true,
+	proto.ClientToBizPublish:      true,
+	proto.ClientToBizUnPublish:    true,
+	proto.ClientToBizSubscribe:    true,
+	proto.ClientToBizUnSubscribe:  true,
+	proto.ClientToBizBroadcast:    true,
+	proto.ClientToBizGetRoomUsers: true,
+	proto.ClientToBizGetRoomPubs:  true,
+	proto.ClientToBizSetRole:      true,
+	proto.ClientToBizMessage:      true,
+	proto.ClientToBizSetState:     true,
+	proto.ClientToBizGetState:     true,
+	proto.ClientToBizDelState:     true,
+	proto.ClientToBizUpdateInfo:   true,
+	proto.ClientToBizKick:         true,
+	proto.ClientToBizStreamUpdate: true,
+	proto.ClientToBizMute:         true,
+	proto.ClientToBizHistory:      true,
+	proto.ClientToBizSetLayer:     true,
+}
+
+// observeRequest 包装回复函数,回复时统计请求结果和耗时
+func observeRequest(method string, accept AcceptFunc, reject RejectFunc) (AcceptFunc, RejectFunc) {
+	if !requestMethods[method] {
+		method = "unknown"
+	}
+	start := time.Now()
+	var once sync.Once
+	observe := func(result string) {
+		once.Do(func() {
+			metricRequests.WithLabelValues(method, result).Inc()
+			metricRequestSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		})
+	}
+	return func(data map[string]interface{}) {
+			observe("ok")
+			accept(data)
+		}, func(errorCode int, errorReason string) {
+			observe("error")
+			reject(errorCode, errorReason)
+		}
+}
+
+// Requestor rpc请求对象,统计请求耗时和错误
+type Requestor struct {
+	*nprotoo.Requestor
+	service string
+}
+
+// NewRequestor 新建Requestor对象,service为对端服务名
+func NewRequestor(req *nprotoo.Requestor, service string) *Requestor {
+	return &Requestor{Requestor: req, service: service}
+}
+
+// SyncRequest 同步请求
+func (req *Requestor) SyncRequest(method string, data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	start := time.Now()
+	resp, err := req.Requestor.SyncRequest(method, data)
+	metricRPCSeconds.WithLabelValues(req.service, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metricRPCErrors.WithLabelValues(req.service, method).Inc()
+	}
+	return resp, err
+}
@@ -3,48 +3,262 @@ package src
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// requestTimeout 服务器请求默认超时时间
+	requestTimeout = 10 * time.Second
 )
 
 type Transcation struct {
 	id     int
 	accept AcceptFunc
 	reject RejectFunc
+	timer  *time.Timer
 }
 
 type Peer struct {
-	emit  *Emitter
-	id    string
-	tcp   *TcpSocket
-	trans map[int]*Transcation
+	emit       *Emitter
+	id         string
+	rooms      map[string]string
+	socket     Socket
+	queue      *SendQueue
+	claims     map[string]*Claims
+	token      string
+	detached   bool
+	missed     []string
+	lost       bool
+	mutex      sync.Mutex
+	trans      map[int]*Transcation
+	transMutex sync.Mutex
 }
 
-func NewPeer(id string, tcp *TcpSocket) *Peer {
+func NewPeer(id string, socket Socket) *Peer {
 	peer := new(Peer)
 	peer.emit = NewEmitter()
 	peer.id = id
-	peer.tcp = tcp
+	peer.rooms = make(map[string]string)
+	peer.claims = make(map[string]*Claims)
+	peer.socket = socket
+	peer.queue = NewSendQueue(socket, func() {
+		go peer.emit.Emit("close", 105, "send queue overflow")
+	})
 	peer.trans = make(map[int]*Transcation)
-	peer.tcp.emit.On("message", peer.handleMessage)
-	peer.tcp.emit.On("error", func(code int, err string) {
+	peer.socket.On("message", peer.handleMessage)
+	peer.socket.On("error", func(code int, err string) {
 		peer.emit.Emit("error", code, err)
 	})
+	peer.socket.On("close", func(code int, err string) {
+		peer.emit.Emit("close", code, err)
+	})
+	go peer.queue.Work()
 	return peer
 }
 
 func (peer *Peer) ID() string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
 	return peer.id
 }
 
+// SetID 设置连接使用的uid
+func (peer *Peer) SetID(uid string) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.id = uid
+}
+
+// RIDs 返回peer加入的所有房间id
+func (peer *Peer) RIDs() []string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	rids := make([]string, 0, len(peer.rooms))
+	for rid := range peer.rooms {
+		rids = append(rids, rid)
+	}
+	return rids
+}
+
+// InRoom 判断peer是否在房间中
+func (peer *Peer) InRoom(rid string) bool {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	_, ok := peer.rooms[rid]
+	return ok
+}
+
+// leaveRoom 删除房间,返回剩余房间数量
+func (peer *Peer) leaveRoom(rid string) int {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	delete(peer.rooms, rid)
+	delete(peer.claims, rid)
+	return len(peer.rooms)
+}
+
+// LastRecv 返回最后一次收到数据的时间
+func (peer *Peer) LastRecv() time.Time {
+	return peer.socket.LastRecv()
+}
+
+// Claims 返回加入房间时校验通过的token数据,token和房间绑定,每个房间单独保存
+func (peer *Peer) Claims(rid string) *Claims {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	return peer.claims[rid]
+}
+
+// SetClaims 保存加入房间时校验通过的token数据
+func (peer *Peer) SetClaims(rid string, claims *Claims) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.claims[rid] = claims
+}
+
+// Role 返回peer在房间中的角色
+func (peer *Peer) Role(rid string) string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	return peer.rooms[rid]
+}
+
+// SetRole 设置peer在房间中的角色,同时记录加入房间
+func (peer *Peer) SetRole(rid, role string) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.rooms[rid] = role
+}
+
+// Token 返回断线重连token
+func (peer *Peer) Token() string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	return peer.token
+}
+
+// NewToken 返回断线重连token,没有时生成,一个连接加入多个房间共用一个token
+func (peer *Peer) NewToken() string {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if peer.token == "" {
+		peer.token = newResumeToken()
+	}
+	return peer.token
+}
+
+// Resume 恢复断开的peer的身份和房间
+func (peer *Peer) Resume(old *Peer) {
+	old.mutex.Lock()
+	id, token := old.id, old.token
+	rooms := make(map[string]string, len(old.rooms))
+	for rid, role := range old.rooms {
+		rooms[rid] = role
+	}
+	claims := make(map[string]*Claims, len(old.claims))
+	for rid, c := range old.claims {
+		claims[rid] = c
+	}
+	old.mutex.Unlock()
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.id = id
+	peer.token = token
+	for rid, role := range rooms {
+		peer.rooms[rid] = role
+	}
+	for rid, c := range claims {
+		peer.claims[rid] = c
+	}
+}
+
+// Detach 暂停发送通知,之后的通知先缓存
+func (peer *Peer) Detach() {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.detached = true
+}
+
+// Missed 取出缓存的通知,缓存满丢弃过通知时返回true
+func (peer *Peer) Missed() ([]string, bool) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	missed := peer.missed
+	peer.missed = nil
+	return missed, peer.lost
+}
+
+// Attach 依次发送missed和缓存的通知,恢复发送
+func (peer *Peer) Attach(missed []string) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	for _, data := range append(missed, peer.missed...) {
+		peer.queue.Push(data, true)
+	}
+	peer.missed = nil
+	peer.detached = false
+}
+
+// send 发送数据,暂停时缓存通知
+func (peer *Peer) send(data string, notify bool) error {
+	peer.mutex.Lock()
+	if peer.detached && notify {
+		if len(peer.missed) < peer.queue.size {
+			peer.missed = append(peer.missed, data)
+		} else {
+			peer.lost = true
+		}
+		peer.mutex.Unlock()
+		return nil
+	}
+	peer.mutex.Unlock()
+	return peer.queue.Push(data, notify)
+}
+
 func (peer *Peer) Work() {
-	peer.tcp.Read()
+	peer.socket.Read()
 }
 
 func (peer *Peer) Close() {
-	peer.tcp.Close()
+	peer.queue.Close()
+	// 拒绝所有未完成的请求
+	peer.transMutex.Lock()
+	trans := peer.trans
+	peer.trans = make(map[int]*Transcation)
+	peer.transMutex.Unlock()
+	for _, transcation := range trans {
+		transcation.timer.Stop()
+		transcation.reject(codeClosedErr, codeStr(codeClosedErr))
+	}
 }
 
+// Request 发送请求给客户端,使用默认超时时间
 func (peer *Peer) Request(method string, data map[string]interface{}, success AcceptFunc, reject RejectFunc) {
+	peer.RequestWithTimeout(method, data, requestTimeout, success, reject)
+}
+
+// RequestWithTimeout 发送请求给客户端,超时未回复调用reject
+func (peer *Peer) RequestWithTimeout(method string, data map[string]interface{}, timeout time.Duration, success AcceptFunc, reject RejectFunc) {
+	peer.transMutex.Lock()
 	id := GenerateRandomNumber()
+	for peer.trans[id] != nil {
+		id = GenerateRandomNumber()
+	}
+	transcation := &Transcation{
+		id:     id,
+		accept: success,
+		reject: reject,
+	}
+	transcation.timer = time.AfterFunc(timeout, func() {
+		if peer.popTranscation(id) != nil {
+			reject(codeTimeoutErr, codeStr(codeTimeoutErr))
+		}
+	})
+	peer.trans[id] = transcation
+	peer.transMutex.Unlock()
+
 	request := &Request{
 		Request: true,
 		Id:      id,
@@ -54,17 +268,31 @@ func (peer *Peer) Request(method string, data map[string]interface{}, success Ac
 
 	str, err := json.Marshal(request)
 	if err != nil {
+		if peer.popTranscation(id) != nil {
+			transcation.timer.Stop()
+			reject(codeUnknownErr, err.Error())
+		}
 		return
 	}
 
-	transcation := &Transcation{
-		id:     id,
-		accept: success,
-		reject: reject,
+	err = peer.send(string(str), false)
+	if err != nil {
+		if peer.popTranscation(id) != nil {
+			transcation.timer.Stop()
+			reject(codeClosedErr, err.Error())
+		}
 	}
+}
 
-	peer.trans[id] = transcation
-	peer.tcp.Send(string(str))
+// popTranscation 取出并删除请求
+func (peer *Peer) popTranscation(id int) *Transcation {
+	peer.transMutex.Lock()
+	defer peer.transMutex.Unlock()
+	transcation := peer.trans[id]
+	if transcation != nil {
+		delete(peer.trans, id)
+	}
+	return transcation
 }
 
 func (peer *Peer) Notify(method string, data map[string]interface{}) {
@@ -80,7 +308,7 @@ func (peer *Peer) Notify(method string, data map[string]interface{}) {
 	}
 
 	fmt.Printf("Send notification [%s]\n", method)
-	peer.tcp.Send(string(str))
+	peer.send(string(str), true)
 }
 
 func (peer *Peer) handleMessage(message []byte) {
@@ -114,7 +342,7 @@ func (peer *Peer) handleRequest(request map[string]interface{}) {
 			return
 		}
 
-		peer.tcp.Send(string(str))
+		peer.send(string(str), false)
 	}
 
 	reject := func(errorCode int, errorReason string) {
@@ -131,27 +359,37 @@ func (peer *Peer) handleRequest(request map[string]interface{}) {
 			return
 		}
 
-		peer.tcp.Send(string(str))
+		peer.send(string(str), false)
 	}
 
 	peer.emit.Emit("request", request, accept, reject)
 }
 
 func (peer *Peer) handleResponse(response map[string]interface{}) {
-	id := int(response["id"].(float64))
-	transcation := peer.trans[id]
+	fid, ok := response["id"].(float64)
+	if !ok {
+		fmt.Printf("received response without id")
+		return
+	}
+	id := int(fid)
+	transcation := peer.popTranscation(id)
 	if transcation == nil {
 		fmt.Printf("received response does not match any sent request [id:%d]", id)
 		return
 	}
+	transcation.timer.Stop()
 
 	if response["ok"] != nil && response["ok"] == true {
-		transcation.accept(response["data"].(map[string]interface{}))
+		data, _ := response["data"].(map[string]interface{})
+		if data == nil {
+			data = emptyMap
+		}
+		transcation.accept(data)
 	} else {
-		transcation.reject(int(response["errorCode"].(float64)), response["errorReason"].(string))
+		errorCode, _ := response["errorCode"].(float64)
+		errorReason, _ := response["errorReason"].(string)
+		transcation.reject(int(errorCode), errorReason)
 	}
-
-	delete(peer.trans, id)
 }
 
 func (peer *Peer) handleNotification(notification map[string]interface{}) {
@@ -0,0 +1,160 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"server/server/biz/conf"
+	"sync"
+	"time"
+)
+
+const (
+	// OverflowDrop 队列满时丢弃最老的通知
+	OverflowDrop = "drop"
+	// OverflowClose 队列满时断开连接
+	OverflowClose = "close"
+
+	// defaultQueueSize 默认发送队列长度
+	defaultQueueSize = 256
+	// flushTimeout 关闭时发送剩余数据的最长时间
+	flushTimeout = time.Second
+)
+
+type queueItem struct {
+	data   string
+	notify bool
+}
+
+// SendQueue peer发送队列,由单独的线程写socket,避免慢客户端阻塞房间
+type SendQueue struct {
+	socket     Socket
+	items      []queueItem
+	size       int
+	policy     string
+	mutex      sync.Mutex
+	signal     chan struct{}
+	stop       chan struct{}
+	closed     bool
+	dropped    int
+	onOverflow func()
+}
+
+// NewSendQueue 新建SendQueue对象
+func NewSendQueue(socket Socket, onOverflow func()) *SendQueue {
+	queue := &SendQueue{
+		socket:     socket,
+		items:      make([]queueItem, 0),
+		size:       conf.Signal.SendQueue,
+		policy:     conf.Signal.Overflow,
+		signal:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		closed:     false,
+		onOverflow: onOverflow,
+	}
+	if queue.size <= 0 {
+		queue.size = defaultQueueSize
+	}
+	if queue.policy != OverflowClose {
+		queue.policy = OverflowDrop
+	}
+	return queue
+}
+
+// Push 数据入队,不阻塞
+func (queue *SendQueue) Push(data string, notify bool) error {
+	queue.mutex.Lock()
+	if queue.closed {
+		queue.mutex.Unlock()
+		return errors.New("send queue closed")
+	}
+
+	if len(queue.items) >= queue.size {
+		// 丢弃最老的通知,没有通知可丢弃时断开连接
+		dropped := false
+		if queue.policy == OverflowDrop {
+			for i, item := range queue.items {
+				if item.notify {
+					queue.items = append(queue.items[:i], queue.items[i+1:]...)
+					dropped = true
+					break
+				}
+			}
+		}
+		if !dropped {
+			// 先标记关闭,之后的Push直接返回,只断开一次
+			queue.closeLocked()
+			queue.mutex.Unlock()
+			fmt.Printf("send queue overflow, size = %d\n", queue.size)
+			queue.onOverflow()
+			return errors.New("send queue overflow")
+		}
+		// 每次积压只打印一次,恢复时打印丢弃的数量
+		if queue.dropped == 0 {
+			fmt.Printf("send queue full, start dropping oldest notifications\n")
+		}
+		queue.dropped++
+	} else if queue.dropped > 0 {
+		fmt.Printf("send queue recovered, dropped = %d\n", queue.dropped)
+		queue.dropped = 0
+	}
+
+	queue.items = append(queue.items, queueItem{data: data, notify: notify})
+	queue.mutex.Unlock()
+
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Work 写线程,依次发送队列数据,退出时关闭socket
+func (queue *SendQueue) Work() {
+	defer queue.socket.Close()
+	for {
+		select {
+		case <-queue.stop:
+			// 发送剩余数据,比如leave的回复
+			queue.flush()
+			return
+		case <-queue.signal:
+			if !queue.flush() {
+				return
+			}
+		}
+	}
+}
+
+// flush 发送队列中的所有数据
+func (queue *SendQueue) flush() bool {
+	queue.mutex.Lock()
+	items := queue.items
+	queue.items = make([]queueItem, 0)
+	queue.mutex.Unlock()
+
+	for _, item := range items {
+		err := queue.socket.Send(item.data)
+		if err != nil {
+			fmt.Printf("send queue write error = %v\n", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Close 关闭队列,剩余数据发送完后关闭socket
+func (queue *SendQueue) Close() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.closeLocked()
+}
+
+// closeLocked 关闭队列,调用时需持有mutex
+func (queue *SendQueue) closeLocked() {
+	if !queue.closed {
+		queue.closed = true
+		close(queue.stop)
+		// 慢客户端不能一直占用连接
+		time.AfterFunc(flushTimeout, queue.socket.Close)
+	}
+}
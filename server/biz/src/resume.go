@@ -0,0 +1,153 @@
+package src
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"server/pkg/proto"
+	"server/pkg/util"
+	"server/server/biz/conf"
+	"sync"
+	"time"
+
+	"github.com/zhuanxin-sz/go-protoo/logger"
+)
+
+// resumeKeepAlive 等待重连期间刷新islb中用户数据的间隔,小于islb的过期时间
+const resumeKeepAlive = 20 * time.Second
+
+// resumeItem 等待重连的peer
+type resumeItem struct {
+	peer  *Peer
+	timer *time.Timer
+	stop  chan struct{}
+}
+
+var (
+	// resumes 等待重连的peer, key = token
+	resumes      = make(map[string]*resumeItem)
+	resumesMutex sync.Mutex
+)
+
+// resumeEnable 是否支持断线重连
+func resumeEnable() bool {
+	return conf.Signal.Resume > 0
+}
+
+// newResumeToken 生成断线重连token
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// holdPeer 连接断开后保留peer,超时未重连走离开流程
+func holdPeer(peer *Peer) {
+	token := peer.Token()
+	resumesMutex.Lock()
+	if resumes[token] != nil {
+		resumesMutex.Unlock()
+		return
+	}
+	item := &resumeItem{
+		peer: peer,
+		timer: time.AfterFunc(time.Duration(conf.Signal.Resume)*time.Second, func() {
+			if takePeer(token, peer.ID()) != nil {
+				logger.Debugf("peer resume timeout uid=%s", peer.ID())
+				leavePeer(peer)
+			}
+		}),
+		stop: make(chan struct{}),
+	}
+	resumes[token] = item
+	resumesMutex.Unlock()
+
+	go holdKeepAlive(item)
+}
+
+// holdKeepAlive 等待重连期间定时延长islb中用户数据的过期时间,避免被CheckRoom删除
+func holdKeepAlive(item *resumeItem) {
+	t := time.NewTicker(resumeKeepAlive)
+	defer t.Stop()
+	for {
+		islbRpc := GetRPCHandlerByServiceName("islb")
+		if islbRpc != nil {
+			for _, rid := range item.peer.RIDs() {
+				islbRpc.SyncRequest(proto.BizToIslbKeepAlive, util.Map("rid", rid, "uid", item.peer.ID()))
+			}
+		}
+		select {
+		case <-t.C:
+		case <-item.stop:
+			return
+		}
+	}
+}
+
+// takePeer 取出等待重连的peer,uid不一致返回nil
+func takePeer(token, uid string) *Peer {
+	resumesMutex.Lock()
+	defer resumesMutex.Unlock()
+	item := resumes[token]
+	if item == nil || item.peer.ID() != uid {
+		return nil
+	}
+	delete(resumes, token)
+	item.timer.Stop()
+	close(item.stop)
+	return item.peer
+}
+
+// leavePeer peer还在房间中则走离开流程
+func leavePeer(peer *Peer) {
+	uid := peer.ID()
+	if uid == "" {
+		return
+	}
+	for _, rid := range peer.RIDs() {
+		room := rooms.GetRoom(rid)
+		if room == nil || room.GetPeer(uid) != peer {
+			continue
+		}
+		ClearPeer(rid, uid)
+	}
+}
+
+// resumePeer 用token恢复断开的peer和所有房间,成功后回复join结果并发送断开期间的通知
+func resumePeer(peer *Peer, token, rid, uid string, accept AcceptFunc) bool {
+	old := takePeer(token, uid)
+	if old == nil {
+		return false
+	}
+
+	// 已经被踢出或离开房间
+	room := rooms.GetRoom(rid)
+	if room == nil || room.GetPeer(uid) != old {
+		leavePeer(old)
+		return false
+	}
+
+	// 替换房间中的peer,通知先缓存,回复join后再发送
+	peer.Resume(old)
+	peer.Detach()
+	islbRpc := GetRPCHandlerByServiceName("islb")
+	for _, id := range old.RIDs() {
+		r := rooms.GetRoom(id)
+		if r == nil || r.GetPeer(uid) != old {
+			peer.leaveRoom(id)
+			continue
+		}
+		r.AddPeer(peer)
+		if islbRpc != nil {
+			islbRpc.SyncRequest(proto.BizToIslbKeepAlive, util.Map("rid", id, "uid", uid))
+		}
+	}
+	missed, lost := old.Missed()
+
+	_, users := FindRoomUsers(rid, uid)
+	_, pubs := FindRoomPubs(rid, uid)
+	_, state := FindRoomState(rid)
+	accept(util.Map("users", users, "pubs", pubs, "state", state, "role", peer.Role(rid), "resume", token, "resumed", true, "lost", lost))
+	peer.Attach(missed)
+	logger.Debugf("peer resumed rid=%s uid=%s missed=%d", rid, uid, len(missed))
+	return true
+}
@@ -0,0 +1,58 @@
+package src
+
+import (
+	"server/pkg/proto"
+	"server/server/biz/conf"
+	"strings"
+)
+
+const (
+	// RoleHost 主持人
+	RoleHost = "host"
+	// RolePresenter 主讲人
+	RolePresenter = "presenter"
+	// RoleViewer 观众
+	RoleViewer = "viewer"
+)
+
+// permissions 方法权限表,不在表中的方法所有角色都可以调用
+var permissions = map[string][]string{
+	proto.ClientToBizPublish:      {RoleHost, RolePresenter},
+	proto.ClientToBizUnPublish:    {RoleHost, RolePresenter},
+	proto.ClientToBizSetRole:      {RoleHost},
+	proto.ClientToBizKick:         {RoleHost},
+	proto.ClientToBizMute:         {RoleHost},
+	proto.ClientToBizStreamUpdate: {RoleHost, RolePresenter},
+}
+
+// roleValid 判断角色是否有效
+func roleValid(role string) bool {
+	return role == RoleHost || role == RolePresenter || role == RoleViewer
+}
+
+// defaultRole 房间配置的默认角色,指定房间的配置优先
+func defaultRole(rid string) string {
+	// 配置中的key都是小写
+	if l, ok := conf.Room.Limits[strings.ToLower(rid)]; ok && roleValid(l.Role) {
+		return l.Role
+	}
+	if roleValid(conf.Room.Role) {
+		return conf.Room.Role
+	}
+	return RolePresenter
+}
+
+// checkPermission 判断peer在房间中是否有权限调用方法
+func checkPermission(peer *Peer, rid, method string) bool {
+	roles, ok := permissions[method]
+	if !ok {
+		return true
+	}
+	role := peer.Role(rid)
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -29,6 +29,9 @@ func (room *Room) ID() string {
 // AddPeer 新增peer
 func (room *Room) AddPeer(peer *Peer) {
 	uid := peer.ID()
+	if room.GetPeer(uid) == peer {
+		return
+	}
 	// 删除老peer
 	room.DelPeer(uid)
 	// 添加新peer
@@ -37,14 +40,24 @@ func (room *Room) AddPeer(peer *Peer) {
 	room.peers[uid] = peer
 }
 
-// DelPeer 删除peer
+// DelPeer 删除peer,peer不在其他房间时关闭连接
 func (room *Room) DelPeer(uid string) {
+	peer := room.RemovePeer(uid)
+	if peer != nil && len(peer.RIDs()) == 0 {
+		peer.Close()
+	}
+}
+
+// RemovePeer 删除peer,不关闭连接
+func (room *Room) RemovePeer(uid string) *Peer {
 	room.peersMutex.Lock()
 	defer room.peersMutex.Unlock()
-	if room.peers[uid] != nil {
-		room.peers[uid].Close()
+	peer := room.peers[uid]
+	if peer != nil {
+		peer.leaveRoom(room.id)
 		delete(room.peers, uid)
 	}
+	return peer
 }
 
 // GetPeer 获取peer
@@ -57,11 +70,33 @@ func (room *Room) GetPeer(uid string) *Peer {
 	return nil
 }
 
+// GetUids 获取所有peer的uid
+func (room *Room) GetUids() []string {
+	room.peersMutex.Lock()
+	defer room.peersMutex.Unlock()
+	uids := make([]string, 0, len(room.peers))
+	for uid := range room.peers {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
 // GetPeers 获取peers
 func (room *Room) GetPeers() map[string]*Peer {
 	return room.peers
 }
 
+// Snapshot 获取peers的副本,遍历时不用持有锁
+func (room *Room) Snapshot() map[string]*Peer {
+	room.peersMutex.Lock()
+	defer room.peersMutex.Unlock()
+	snapshot := make(map[string]*Peer, len(room.peers))
+	for uid, peer := range room.peers {
+		snapshot[uid] = peer
+	}
+	return snapshot
+}
+
 // MapPeers 遍历所有的peer
 func (room *Room) MapPeers(fn func(string, *Peer)) {
 	room.peersMutex.Lock()
@@ -108,7 +143,10 @@ func (room *Room) Close() {
 	room.peersMutex.Lock()
 	defer room.peersMutex.Unlock()
 	log.Printf("Close Room rid=%s", room.id)
-	for _, peer := range room.peers {
-		peer.Close()
+	for uid, peer := range room.peers {
+		delete(room.peers, uid)
+		if peer.leaveRoom(room.id) == 0 {
+			peer.Close()
+		}
 	}
 }
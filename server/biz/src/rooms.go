@@ -55,6 +55,24 @@ func (rooms *Rooms) GetRooms() map[string]*Room {
 	return rooms.roomMap
 }
 
+// Snapshot 获取rooms的副本,遍历时不用持有锁
+func (rooms *Rooms) Snapshot() map[string]*Room {
+	rooms.roomsMutex.Lock()
+	defer rooms.roomsMutex.Unlock()
+	snapshot := make(map[string]*Room, len(rooms.roomMap))
+	for rid, room := range rooms.roomMap {
+		snapshot[rid] = room
+	}
+	return snapshot
+}
+
+// Count 获取房间数量
+func (rooms *Rooms) Count() int {
+	rooms.roomsMutex.Lock()
+	defer rooms.roomsMutex.Unlock()
+	return len(rooms.roomMap)
+}
+
 // NotifyWithUid 通知房间指定人
 func (rooms *Rooms) NotifyWithUid(rid, uid, method string, data map[string]interface{}) {
 	room := rooms.GetRoom(rid)
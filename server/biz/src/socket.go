@@ -1,17 +1,50 @@
 package src
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"server/server/biz/conf"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// FramingV1 2字节包长度,老版本客户端
+	FramingV1 = 1
+	// FramingV2 4字节包长度,连接建立后客户端先发送握手
+	FramingV2 = 2
+
+	// framingMagic 握手标记,v1包长度最大为0xFFFE,第一个包的长度为0xFFFF时是v2握手
+	framingMagic = 0xFF
+	// defaultMaxFrame 默认最大包长度
+	defaultMaxFrame = 1024 * 1024
+	// maxFrameV1 v1最大包长度,0xFFFF保留给握手
+	maxFrameV1 = 0xFFFE
+)
+
+// Socket 信令连接接口,TcpSocket和WsSocket都实现该接口
+type Socket interface {
+	On(event, listener interface{})
+	Read()
+	Send(msg string) error
+	Close()
+	LastRecv() time.Time
+}
+
 type TcpSocket struct {
-	emit  *Emitter
-	conn  net.Conn
-	mutex sync.Mutex
-	close bool
+	emit      *Emitter
+	conn      net.Conn
+	mutex     sync.Mutex
+	close     bool
+	closeOnce sync.Once
+	framing   int32
+	maxFrame  int
+	idle      time.Duration
+	recvTime  int64
 }
 
 func NewTcpSocket(conn net.Conn) *TcpSocket {
@@ -19,9 +52,46 @@ func NewTcpSocket(conn net.Conn) *TcpSocket {
 	tcp.emit = NewEmitter()
 	tcp.conn = conn
 	tcp.close = false
+	tcp.framing = FramingV1
+	tcp.maxFrame = maxFrameSize()
+	tcp.idle = idleTimeout()
+	tcp.recvTime = time.Now().UnixNano()
 	return tcp
 }
 
+// maxFrameSize 配置的最大包长度
+func maxFrameSize() int {
+	if conf.Signal.MaxFrame > 0 {
+		return conf.Signal.MaxFrame
+	}
+	return defaultMaxFrame
+}
+
+// idleTimeout 配置的空闲超时时间,为0不检查
+func idleTimeout() time.Duration {
+	return time.Duration(conf.Signal.Idle) * time.Second
+}
+
+// isTimeout 判断是否读超时
+func isTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+func (tcp *TcpSocket) On(event, listener interface{}) {
+	tcp.emit.On(event, listener)
+}
+
+// LastRecv 返回最后一次收到数据的时间
+func (tcp *TcpSocket) LastRecv() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&tcp.recvTime))
+}
+
+// Framing 返回连接协商的分帧协议,不使用发送锁,避免读线程被阻塞的写卡住
+func (tcp *TcpSocket) Framing() int {
+	return int(atomic.LoadInt32(&tcp.framing))
+}
+
 func (tcp *TcpSocket) Read() {
 	msg := make(chan []byte)
 	stop := make(chan int)
@@ -41,31 +111,31 @@ func (tcp *TcpSocket) Read() {
 
 // read thread
 func (tcp *TcpSocket) DoRead(msg chan []byte, stop chan int) {
+	first := true
 	for {
 		if tcp.close {
 			close(stop)
 			return
 		}
 
+		// 设置空闲超时
+		if tcp.idle > 0 {
+			tcp.conn.SetReadDeadline(time.Now().Add(tcp.idle))
+		}
+
 		// read json data len
-		byLen := make([]byte, 2)
-		recvLen, err := tcp.conn.Read(byLen)
-		if (err != nil) || (recvLen != 2) {
+		nLen, err := tcp.readLen(first)
+		first = false
+		if err != nil {
 			fmt.Printf("tcp recv len error = %v\n", err)
 			close(stop)
 			// exit
-			if !tcp.close {
-				tcp.emit.Emit("error", 101, "tcp recv len error")
-			}
+			tcp.onReadErr(101, "tcp recv len error", err)
 			return
 		}
-
-		// calc json data len
-		nLen := uint32(byLen[0])
-		nLen += (uint32(byLen[1]) << 8)
 		fmt.Printf("tcp recv len = %d\n", nLen)
 
-		if nLen > 4096 {
+		if nLen > uint32(tcp.maxFrame) {
 			close(stop)
 			// exit
 			if !tcp.close {
@@ -75,27 +145,91 @@ func (tcp *TcpSocket) DoRead(msg chan []byte, stop chan int) {
 		}
 
 		// read json data
-		nIndex := 0
 		byData := make([]byte, nLen)
-		for nIndex < int(nLen) {
-			recvLen, err = tcp.conn.Read(byData[nIndex:])
-			if err != nil {
-				fmt.Printf("tcp recv data error = %v\n", err)
-				close(stop)
-				// exit
-				if !tcp.close {
-					tcp.emit.Emit("error", 103, "tcp recv data error")
-				}
-				return
-			}
-			nIndex += recvLen
+		_, err = io.ReadFull(tcp.conn, byData)
+		if err != nil {
+			fmt.Printf("tcp recv data error = %v\n", err)
+			close(stop)
+			// exit
+			tcp.onReadErr(103, "tcp recv data error", err)
+			return
 		}
 
+		atomic.StoreInt64(&tcp.recvTime, time.Now().UnixNano())
 		fmt.Printf("tcp recv data = %s\n", string(byData))
 		msg <- byData
 	}
 }
 
+// onReadErr 读数据出错, 超时通过close事件上报, 其他通过error事件上报
+func (tcp *TcpSocket) onReadErr(code int, reason string, err error) {
+	if tcp.close {
+		return
+	}
+	if isTimeout(err) {
+		tcp.emit.Emit("close", 104, "tcp idle timeout")
+		return
+	}
+	tcp.emit.Emit("error", code, fmt.Sprintf("%s: %v", reason, err))
+}
+
+// readLen 读取包长度,第一个包判断是否为v2握手
+func (tcp *TcpSocket) readLen(first bool) (uint32, error) {
+	byLen := make([]byte, 4)
+	_, err := io.ReadFull(tcp.conn, byLen[:2])
+	if err != nil {
+		return 0, err
+	}
+
+	if first && byLen[0] == framingMagic && byLen[1] == framingMagic {
+		// 握手 = 0xFF 0xFF version
+		byVer := make([]byte, 1)
+		_, err = io.ReadFull(tcp.conn, byVer)
+		if err != nil {
+			return 0, err
+		}
+		if byVer[0] != FramingV2 {
+			return 0, fmt.Errorf("tcp framing version %d not support", byVer[0])
+		}
+		// 回复相同握手
+		err = tcp.handshake(FramingV2)
+		if err != nil {
+			return 0, err
+		}
+		return tcp.readLen(false)
+	}
+
+	if tcp.Framing() == FramingV2 {
+		_, err = io.ReadFull(tcp.conn, byLen[2:])
+		if err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint32(byLen), nil
+	}
+	nLen := uint32(binary.LittleEndian.Uint16(byLen[:2]))
+	if nLen > maxFrameV1 {
+		return 0, fmt.Errorf("tcp framing v1 len %d reserved", nLen)
+	}
+	return nLen, nil
+}
+
+// handshake 设置分帧协议并回复握手
+func (tcp *TcpSocket) handshake(framing int) error {
+	tcp.mutex.Lock()
+	defer tcp.mutex.Unlock()
+	if tcp.close {
+		return errors.New("tcp write closed")
+	}
+
+	atomic.StoreInt32(&tcp.framing, int32(framing))
+	_, err := tcp.conn.Write([]byte{framingMagic, framingMagic, byte(framing)})
+	if err != nil {
+		return errors.New("tcp write fail")
+	}
+	fmt.Printf("tcp framing = %d\n", framing)
+	return nil
+}
+
 func (tcp *TcpSocket) Send(msg string) error {
 	tcp.mutex.Lock()
 	defer tcp.mutex.Unlock()
@@ -103,12 +237,21 @@ func (tcp *TcpSocket) Send(msg string) error {
 		return errors.New("tcp write closed")
 	}
 
-	// add len 2 byte
+	// add len 2 byte or 4 byte
+	var byData []byte
 	nLen := len(msg)
-	byData := make([]byte, nLen+2)
-	byData[0] = byte(nLen)
-	byData[1] = byte(nLen >> 8)
-	copy(byData[2:], []byte(msg))
+	if tcp.Framing() == FramingV2 {
+		byData = make([]byte, nLen+4)
+		binary.LittleEndian.PutUint32(byData, uint32(nLen))
+		copy(byData[4:], []byte(msg))
+	} else {
+		if nLen > maxFrameV1 {
+			return fmt.Errorf("tcp write len %d too large", nLen)
+		}
+		byData = make([]byte, nLen+2)
+		binary.LittleEndian.PutUint16(byData, uint16(nLen))
+		copy(byData[2:], []byte(msg))
+	}
 	fmt.Printf("tcp send data = %s\n", msg)
 
 	_, err := tcp.conn.Write(byData)
@@ -118,11 +261,10 @@ func (tcp *TcpSocket) Send(msg string) error {
 	return nil
 }
 
+// Close 关闭连接,不等待发送锁,可以打断阻塞的写
 func (tcp *TcpSocket) Close() {
-	tcp.mutex.Lock()
-	defer tcp.mutex.Unlock()
-	if !tcp.close {
+	tcp.closeOnce.Do(func() {
 		tcp.close = true
 		tcp.conn.Close()
-	}
+	})
 }
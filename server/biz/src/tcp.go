@@ -1,11 +1,13 @@
 package src
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"server/pkg/util"
 	"strconv"
+	"sync"
 )
 
 // err code
@@ -26,15 +28,20 @@ func DefaultReject(errorCode int, errorReason string) {
 	log.Printf("tcp reject errorCode => %v errorReason => %v", errorCode, errorReason)
 }
 
-// Start tcp server
-func StartTcp(ip string, port uint16) {
-	fmt.Println("tcp server start")
+// Start tcp server, config不为空时启用tls
+func StartTcp(ip string, port uint16, config *tls.Config) {
 	url := ip + ":" + strconv.Itoa(int(port))
 	lner, err := net.Listen("tcp", url)
 	if err != nil {
 		fmt.Printf("tcp server listen error = %v", err)
 		return
 	}
+	if config != nil {
+		lner = tls.NewListener(lner, config)
+		fmt.Printf("tls server start on %s\n", url)
+	} else {
+		fmt.Printf("tcp server start on %s\n", url)
+	}
 
 	for {
 		conn, err := lner.Accept()
@@ -42,7 +49,7 @@ func StartTcp(ip string, port uint16) {
 			fmt.Printf("server accept error = %v", err)
 			break
 		}
-		go handleConnection(conn)
+		go handleSocket(NewTcpSocket(conn))
 	}
 
 	if lner != nil {
@@ -51,9 +58,11 @@ func StartTcp(ip string, port uint16) {
 	fmt.Println("tcp server stop")
 }
 
-func handleConnection(conn net.Conn) {
-	tcp := NewTcpSocket(conn)
-	peer := NewPeer("", tcp)
+// handleSocket 处理信令连接,tcp和websocket共用
+func handleSocket(socket Socket) {
+	peer := NewPeer("", socket)
+	metricConns.Inc()
+	defer metricConns.Dec()
 
 	handleRequest := func(request map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
 		method := util.Val(request, "method")
@@ -89,8 +98,23 @@ func handleConnection(conn net.Conn) {
 		handlerWebsocket(method, peer, msg, DefaultAccept, DefaultReject)
 	}
 
+	// 读错误和发送队列溢出可能同时触发关闭,只处理一次
+	var closeOnce sync.Once
 	handleClose := func(code int, err string) {
-		peer.Close()
+		closeOnce.Do(func() {
+			fmt.Printf("peer close uid = %s, code = %d, err = %s\n", peer.ID(), code, err)
+			// 支持断线重连时保留peer和流,缓存通知等待重连
+			if resumeEnable() && peer.Token() != "" {
+				peer.Detach()
+				peer.Close()
+				holdPeer(peer)
+				return
+			}
+
+			// 连接断开,peer还在房间中则走离开流程
+			peer.Close()
+			leavePeer(peer)
+		})
 	}
 
 	peer.emit.On("request", handleRequest)
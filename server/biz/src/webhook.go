@@ -0,0 +1,198 @@
+package src
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"server/pkg/proto"
+	"server/pkg/util"
+	"server/server/biz/conf"
+	"time"
+
+	"github.com/zhuanxin-sz/go-protoo/logger"
+)
+
+const (
+	// WebhookRoomCreated 集群中房间第一个人加入
+	WebhookRoomCreated = "room-created"
+	// WebhookRoomDestroyed 集群中房间最后一个人离开
+	WebhookRoomDestroyed = "room-destroyed"
+
+	// defaultWebhookTimeout 默认请求超时时间
+	defaultWebhookTimeout = 5 * time.Second
+	// defaultWebhookBackoff 默认第一次重试间隔
+	defaultWebhookBackoff = time.Second
+	// maxWebhookBackoff 最长重试间隔
+	maxWebhookBackoff = 60 * time.Second
+	// defaultWebhookQueue 默认队列长度
+	defaultWebhookQueue = 1024
+)
+
+// webhookItem 等待发送的事件
+type webhookItem struct {
+	id      string
+	event   string
+	body    []byte
+	attempt int
+}
+
+var (
+	webhookQueue  chan *webhookItem
+	webhookClient *http.Client
+	webhookEvents map[string]bool
+)
+
+// startWebhook 启动事件回调,没有配置url时不启用
+func startWebhook() {
+	if conf.Webhook.URL == "" {
+		return
+	}
+	size := conf.Webhook.Queue
+	if size <= 0 {
+		size = defaultWebhookQueue
+	}
+	timeout := time.Duration(conf.Webhook.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	webhookEvents = make(map[string]bool)
+	for _, event := range conf.Webhook.Events {
+		webhookEvents[event] = true
+	}
+	webhookClient = &http.Client{Timeout: timeout}
+	webhookQueue = make(chan *webhookItem, size)
+	go webhookWork()
+	logger.Debugf("Start biz webhook to %s", conf.Webhook.URL)
+}
+
+// webhookNotify 根据房间通知生成回调事件,只由发起通知的biz调用
+func webhookNotify(rid, uid, method string, msg map[string]interface{}) {
+	if webhookQueue == nil {
+		return
+	}
+	switch method {
+	case proto.BizToClientOnJoin:
+		// islb返回加入后的房间人数,只有第一个人看到1
+		if peers, ok := msg["peers"]; ok && util.InterfaceToInt(peers) == 1 {
+			webhookPush(WebhookRoomCreated, rid, "", nil)
+		}
+		webhookPush(method, rid, uid, msg)
+	case proto.BizToClientOnLeave:
+		// islb返回离开后的房间人数,重复离开时为-1,不回调
+		peers, ok := msg["peers"]
+		if ok && util.InterfaceToInt(peers) < 0 {
+			return
+		}
+		webhookPush(method, rid, uid, msg)
+		if ok && util.InterfaceToInt(peers) == 0 {
+			webhookPush(WebhookRoomDestroyed, rid, "", nil)
+		}
+	case proto.BizToClientOnStreamAdd, proto.BizToClientOnStreamRemove:
+		webhookPush(method, rid, uid, msg)
+	}
+}
+
+// webhookPush 事件入队,队列满时丢弃
+func webhookPush(event, rid, uid string, data map[string]interface{}) {
+	if len(webhookEvents) > 0 && !webhookEvents[event] {
+		return
+	}
+	if data == nil {
+		data = emptyMap
+	}
+
+	id := newWebhookID()
+	body, err := json.Marshal(util.Map("id", id, "event", event, "ts", time.Now().UnixNano()/int64(time.Millisecond),
+		"bizid", node.NodeInfo().Nid, "rid", rid, "uid", uid, "data", data))
+	if err != nil {
+		logger.Errorf("biz.webhookPush marshal event=%s err=%v", event, err)
+		return
+	}
+	webhookEnqueue(&webhookItem{id: id, event: event, body: body})
+}
+
+// webhookEnqueue 放入发送队列,不阻塞
+func webhookEnqueue(item *webhookItem) {
+	select {
+	case webhookQueue <- item:
+	default:
+		logger.Errorf("biz.webhook queue full, drop event=%s id=%s", item.event, item.id)
+	}
+}
+
+// webhookWork 发送线程,失败后按间隔翻倍重试
+func webhookWork() {
+	for item := range webhookQueue {
+		err := webhookSend(item)
+		if err == nil {
+			continue
+		}
+		if item.attempt >= conf.Webhook.Retry {
+			logger.Errorf("biz.webhook drop event=%s id=%s after %d retry, err=%v", item.event, item.id, item.attempt, err)
+			continue
+		}
+		delay := webhookBackoff(item.attempt)
+		item.attempt++
+		logger.Warnf("biz.webhook send event=%s id=%s err=%v, retry %d in %v", item.event, item.id, err, item.attempt, delay)
+		retry := item
+		time.AfterFunc(delay, func() {
+			webhookEnqueue(retry)
+		})
+	}
+}
+
+// webhookBackoff 第attempt次重试的间隔
+func webhookBackoff(attempt int) time.Duration {
+	delay := time.Duration(conf.Webhook.Backoff) * time.Second
+	if delay <= 0 {
+		delay = defaultWebhookBackoff
+	}
+	for i := 0; i < attempt && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookBackoff {
+		delay = maxWebhookBackoff
+	}
+	return delay
+}
+
+// webhookSend 发送一次,返回2xx视为成功
+func webhookSend(item *webhookItem) error {
+	req, err := http.NewRequest(http.MethodPost, conf.Webhook.URL, bytes.NewReader(item.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event", item.event)
+	req.Header.Set("X-Event-Id", item.id)
+	req.Header.Set("X-Signature", webhookSign(item.body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookSign 计算签名 = hex(hmac-sha256(secret, body))
+func webhookSign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(conf.Webhook.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookID 生成事件id,重试时不变,接收方可以用来去重
+func newWebhookID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package src
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPath websocket信令地址
+	wsPath = "/ws"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// WsSocket websocket信令连接
+type WsSocket struct {
+	emit      *Emitter
+	conn      *websocket.Conn
+	mutex     sync.Mutex
+	close     bool
+	closeOnce sync.Once
+	idle      time.Duration
+	recvTime  int64
+}
+
+// NewWsSocket 新建WsSocket对象
+func NewWsSocket(conn *websocket.Conn) *WsSocket {
+	ws := new(WsSocket)
+	ws.emit = NewEmitter()
+	ws.conn = conn
+	ws.conn.SetReadLimit(int64(maxFrameSize()))
+	ws.close = false
+	ws.idle = idleTimeout()
+	ws.recvTime = time.Now().UnixNano()
+	return ws
+}
+
+func (ws *WsSocket) On(event, listener interface{}) {
+	ws.emit.On(event, listener)
+}
+
+// LastRecv 返回最后一次收到数据的时间
+func (ws *WsSocket) LastRecv() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&ws.recvTime))
+}
+
+// Read 读取数据,一个websocket消息对应一个json数据
+func (ws *WsSocket) Read() {
+	for {
+		if ws.close {
+			return
+		}
+
+		// 设置空闲超时
+		if ws.idle > 0 {
+			ws.conn.SetReadDeadline(time.Now().Add(ws.idle))
+		}
+
+		_, byData, err := ws.conn.ReadMessage()
+		if err != nil {
+			fmt.Printf("ws recv data error = %v\n", err)
+			// exit
+			if !ws.close {
+				if isTimeout(err) {
+					ws.emit.Emit("close", 104, "ws idle timeout")
+				} else {
+					ws.emit.Emit("error", 103, fmt.Sprintf("ws recv data error: %v", err))
+				}
+			}
+			return
+		}
+
+		atomic.StoreInt64(&ws.recvTime, time.Now().UnixNano())
+		fmt.Printf("ws recv data = %s\n", string(byData))
+		ws.emit.Emit("message", byData)
+	}
+}
+
+func (ws *WsSocket) Send(msg string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.close {
+		return errors.New("ws write closed")
+	}
+
+	fmt.Printf("ws send data = %s\n", msg)
+	err := ws.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	if err != nil {
+		return errors.New("ws write fail")
+	}
+	return nil
+}
+
+// Close 关闭连接,不等待发送锁,可以打断阻塞的写
+func (ws *WsSocket) Close() {
+	ws.closeOnce.Do(func() {
+		ws.close = true
+		ws.conn.Close()
+	})
+}
+
+// StartWebsocket 启动websocket server, config不为空时启用tls
+func StartWebsocket(ip string, port uint16, config *tls.Config) {
+	url := ip + ":" + strconv.Itoa(int(port))
+	mux := http.NewServeMux()
+	mux.HandleFunc(wsPath, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			fmt.Printf("ws upgrade error = %v\n", err)
+			return
+		}
+		handleSocket(NewWsSocket(conn))
+	})
+
+	server := &http.Server{
+		Addr:      url,
+		Handler:   mux,
+		TLSConfig: config,
+	}
+
+	var err error
+	if config != nil {
+		fmt.Printf("wss server start on %s%s\n", url, wsPath)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		fmt.Printf("ws server start on %s%s\n", url, wsPath)
+		err = server.ListenAndServe()
+	}
+	fmt.Printf("ws server stop, err = %v\n", err)
+}
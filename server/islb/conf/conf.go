@@ -18,6 +18,8 @@ var (
 	Nats = &cfg.Nats
 	// Redis Redis设置
 	Redis = &cfg.Redis
+	// History 房间广播历史设置
+	History = &cfg.History
 )
 
 func init() {
@@ -48,11 +50,17 @@ type redis struct {
 	DB    int      `mapstructure:"db"`
 }
 
+type history struct {
+	Size int `mapstructure:"size"`
+	TTL  int `mapstructure:"ttl"`
+}
+
 type config struct {
-	Global  global `mapstructure:"global"`
-	Etcd    etcd   `mapstructure:"etcd"`
-	Nats    nats   `mapstructure:"nats"`
-	Redis   redis  `mapstructure:"redis"`
+	Global  global  `mapstructure:"global"`
+	Etcd    etcd    `mapstructure:"etcd"`
+	Nats    nats    `mapstructure:"nats"`
+	Redis   redis   `mapstructure:"redis"`
+	History history `mapstructure:"history"`
 	CfgFile string
 }
 
@@ -8,6 +8,7 @@ import (
 	"server/server/islb/conf"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zhuanxin-sz/go-protoo/logger"
 	nprotoo "github.com/zhuanxin-sz/nats-protoo"
 )
@@ -51,5 +52,6 @@ func Stop() {
 
 func debug() {
 	logger.Debugf("Start islb pprof on %s", conf.Global.Pprof)
+	http.Handle("/metrics", promhttp.Handler())
 	http.ListenAndServe(conf.Global.Pprof, nil)
 }
@@ -1,10 +1,14 @@
 package src
 
 import (
+	"encoding/json"
 	"fmt"
 	"server/pkg/proto"
 	"server/pkg/util"
+	"server/server/islb/conf"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zhuanxin-sz/go-protoo/logger"
 	nprotoo "github.com/zhuanxin-sz/nats-protoo"
@@ -21,6 +25,7 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 
 	method := request["method"].(string)
 	data := request["data"].(map[string]interface{})
+	start := time.Now()
 
 	var result map[string]interface{}
 	err := &nprotoo.Error{Code: 400, Reason: fmt.Sprintf("Unkown method [%s]", method)}
@@ -37,6 +42,8 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 		result, err = streamAdd(data)
 	case proto.BizToIslbOnStreamRemove:
 		result, err = streamRemove(data)
+	case proto.BizToIslbOnStreamUpdate:
+		result, err = streamUpdate(data)
 	case proto.BizToIslbGetBizInfo:
 		result, err = getBizByUid(data)
 	case proto.BizToIslbGetSfuInfo:
@@ -45,8 +52,25 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 		result, err = getRoomUsers(data)
 	case proto.BizToIslbGetRoomPubs:
 		result, err = getRoomPubs(data)
+	case proto.BizToIslbSetState:
+		result, err = setState(data)
+	case proto.BizToIslbGetState:
+		result, err = getState(data)
+	case proto.BizToIslbDelState:
+		result, err = delState(data)
+	case proto.BizToIslbUpdateInfo:
+		result, err = updateInfo(data)
+	case proto.BizToIslbSetBlock:
+		result, err = setBlock(data)
+	case proto.BizToIslbGetBlock:
+		result, err = getBlock(data)
+	case proto.BizToIslbAddHistory:
+		result, err = addHistory(data)
+	case proto.BizToIslbGetHistory:
+		result, err = getHistory(data)
 	}
 	// 判断成功
+	observeRequest(method, start, err)
 	if err != nil {
 		reject(err.Code, err.Reason)
 	} else {
@@ -55,7 +79,7 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 }
 
 /*
-	"method", proto.BizToIslbOnJoin, "rid", rid, "uid", uid, "bizid", bizid
+	"method", proto.BizToIslbOnJoin, "rid", rid, "uid", uid, "bizid", bizid, "info", info, "maxpeers", maxpeers
 */
 // 有人加入房间
 func clientJoin(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
@@ -63,14 +87,34 @@ func clientJoin(data map[string]interface{}) (map[string]interface{}, *nprotoo.E
 	rid := util.Val(data, "rid")
 	uid := util.Val(data, "uid")
 	bizid := util.Val(data, "bizid")
+	// 房间人数计数
+	count, err := countAdd(proto.GetRoomPeersKey(rid), uid, redisShort, util.InterfaceToInt(data["maxpeers"]))
+	if err != nil {
+		logger.Errorf("islb.clientJoin countAdd err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 401, Reason: fmt.Sprintf("clientJoin err=%v", err)}
+	}
+	if count < 0 {
+		return nil, &nprotoo.Error{Code: 415, Reason: fmt.Sprintf("room is full, rid=%s", rid)}
+	}
 	// 获取用户的Biz服务器
 	uKey := proto.GetUserNodeKey(rid, uid)
-	err := redis.Set(uKey, bizid, redisShort)
+	err = redis.Set(uKey, bizid, redisShort)
 	if err != nil {
 		logger.Errorf("islb.clientJoin redis.Set err=%v, data=%v", err, data)
 		return nil, &nprotoo.Error{Code: 401, Reason: fmt.Sprintf("clientJoin err=%v", err)}
 	}
-	return util.Map("rid", rid, "uid", uid, "bizid", bizid), nil
+	// 获取用户的信息
+	iKey := proto.GetUserInfoKey(rid, uid)
+	info, ok := data["info"].(map[string]interface{})
+	if ok {
+		err = redis.Set(iKey, util.Marshal(info), redisShort)
+		if err != nil {
+			logger.Errorf("islb.clientJoin info redis.Set err=%v, data=%v", err, data)
+		}
+	} else {
+		redis.Del(iKey)
+	}
+	return util.Map("rid", rid, "uid", uid, "bizid", bizid, "info", data["info"], "peers", count), nil
 }
 
 /*
@@ -90,7 +134,18 @@ func clientLeave(data map[string]interface{}) (map[string]interface{}, *nprotoo.
 			logger.Errorf("islb.clientLeave redis.Del err=%v, data=%v", err, data)
 		}
 	}
-	return util.Map("rid", rid, "uid", uid), nil
+	// 获取用户的信息
+	iKey := proto.GetUserInfoKey(rid, uid)
+	err := redis.Del(iKey)
+	if err != nil {
+		logger.Errorf("islb.clientLeave info redis.Del err=%v, data=%v", err, data)
+	}
+	// 房间人数计数, 重复离开时为-1
+	count, err := countDel(proto.GetRoomPeersKey(rid), uid)
+	if err != nil {
+		logger.Errorf("islb.clientLeave countDel err=%v, data=%v", err, data)
+	}
+	return util.Map("rid", rid, "uid", uid, "peers", count), nil
 }
 
 /*
@@ -107,11 +162,16 @@ func keepalive(data map[string]interface{}) (map[string]interface{}, *nprotoo.Er
 		logger.Errorf("islb.keepalive redis.Expire err=%v, data=%v", err, data)
 		return nil, &nprotoo.Error{Code: 402, Reason: fmt.Sprintf("keepalive err=%v", err)}
 	}
+	// 获取用户的信息
+	iKey := proto.GetUserInfoKey(rid, uid)
+	redis.Expire(iKey, redisShort)
+	// 房间人数计数
+	countAdd(proto.GetRoomPeersKey(rid), uid, redisShort, 0)
 	return util.Map("rid", rid, "uid", uid), nil
 }
 
 /*
-	"method", proto.BizToIslbOnStreamAdd, "rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", minfo
+	"method", proto.BizToIslbOnStreamAdd, "rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", minfo, "maxpubs", maxpubs
 */
 // 有人发布流
 func streamAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
@@ -121,9 +181,18 @@ func streamAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Er
 	mid := util.Val(data, "mid")
 	sfuid := util.Val(data, "sfuid")
 	minfo := util.Val(data, "minfo")
+	// 房间推流人数计数
+	count, err := countAdd(proto.GetRoomPublishersKey(rid), uid, redisKeyTTL, util.InterfaceToInt(data["maxpubs"]))
+	if err != nil {
+		logger.Errorf("islb.streamAdd countAdd err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 405, Reason: fmt.Sprintf("streamAdd err=%v", err)}
+	}
+	if count < 0 {
+		return nil, &nprotoo.Error{Code: 416, Reason: fmt.Sprintf("room publishers is full, rid=%s", rid)}
+	}
 	// 获取用户流的信息
 	ukey := proto.GetMediaInfoKey(rid, uid, mid)
-	err := redis.Set(ukey, minfo, redisKeyTTL)
+	err = redis.Set(ukey, minfo, redisKeyTTL)
 	if err != nil {
 		logger.Errorf("islb.streamAdd media redis.Set err=%v, data=%v", err, data)
 		return nil, &nprotoo.Error{Code: 405, Reason: fmt.Sprintf("streamAdd err=%v", err)}
@@ -139,6 +208,29 @@ func streamAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Er
 	return util.Map("rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid, "minfo", data["minfo"]), nil
 }
 
+/*
+	"method", proto.BizToIslbOnStreamUpdate, "rid", rid, "uid", uid, "mid", mid, "minfo", minfo
+*/
+// 有人修改流信息
+func streamUpdate(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	logger.Debugf("islb.streamUpdate data=%v", data)
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	mid := util.Val(data, "mid")
+	minfo := util.Val(data, "minfo")
+	// 获取用户流的信息
+	ukey := proto.GetMediaInfoKey(rid, uid, mid)
+	if redis.Exists(ukey) == 0 {
+		return nil, &nprotoo.Error{Code: 411, Reason: fmt.Sprintf("can't find media by key:%s", ukey)}
+	}
+	err := redis.Set(ukey, minfo, redisKeyTTL)
+	if err != nil {
+		logger.Errorf("islb.streamUpdate media redis.Set err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 405, Reason: fmt.Sprintf("streamUpdate err=%v", err)}
+	}
+	return util.Map("rid", rid, "uid", uid, "mid", mid, "minfo", data["minfo"]), nil
+}
+
 /*
 	"method", proto.BizToIslbOnStreamRemove, "rid", rid, "uid", uid, "mid", ""
 */
@@ -168,12 +260,13 @@ func streamRemove(data map[string]interface{}) (map[string]interface{}, *nprotoo
 			ukey = key
 			arr := strings.Split(key, "/")
 			mid := arr[7]
+			sfuid := redis.Get(ukey)
 			// 删除key值
 			err := redis.Del(ukey)
 			if err != nil {
 				logger.Errorf("islb.streamRemove pub redis.Del err=%v, data=%v", err, data)
 			}
-			rmPubs = append(rmPubs, util.Map("rid", rid, "uid", uid, "mid", mid))
+			rmPubs = append(rmPubs, util.Map("rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid))
 		}
 	} else {
 		// 获取用户流的信息
@@ -194,17 +287,81 @@ func streamRemove(data map[string]interface{}) (map[string]interface{}, *nprotoo
 			ukey = key
 			arr := strings.Split(key, "/")
 			mid := arr[7]
+			sfuid := redis.Get(ukey)
 			// 删除key值
 			err := redis.Del(ukey)
 			if err != nil {
 				logger.Errorf("islb.streamRemove pub redis.Del err=%v, data=%v", err, data)
 			}
-			rmPubs = append(rmPubs, util.Map("rid", rid, "uid", uid, "mid", mid))
+			rmPubs = append(rmPubs, util.Map("rid", rid, "uid", uid, "mid", mid, "sfuid", sfuid))
+		}
+	}
+	// 没有流时减少推流人数计数
+	if len(redis.Keys("/pub/rid/"+rid+"/uid/"+uid+"/mid/*")) == 0 {
+		_, err := countDel(proto.GetRoomPublishersKey(rid), uid)
+		if err != nil {
+			logger.Errorf("islb.streamRemove countDel err=%v, data=%v", err, data)
 		}
 	}
 	return util.Map("rmPubs", rmPubs), nil
 }
 
+/*
+	"method", proto.BizToIslbUpdateInfo, "rid", rid, "uid", uid, "info", info
+*/
+// 修改用户信息
+func updateInfo(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	logger.Debugf("islb.updateInfo data=%v", data)
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	// 用户不在房间
+	uKey := proto.GetUserNodeKey(rid, uid)
+	if redis.Exists(uKey) == 0 {
+		return nil, &nprotoo.Error{Code: 410, Reason: fmt.Sprintf("can't find biz node by key:%s", uKey)}
+	}
+	// 获取用户的信息
+	iKey := proto.GetUserInfoKey(rid, uid)
+	err := redis.Set(iKey, util.Val(data, "info"), redisShort)
+	if err != nil {
+		logger.Errorf("islb.updateInfo redis.Set err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 403, Reason: fmt.Sprintf("updateInfo err=%v", err)}
+	}
+	return util.Map("rid", rid, "uid", uid, "info", data["info"]), nil
+}
+
+/*
+	"method", proto.BizToIslbSetBlock, "rid", rid, "uid", uid, "ttl", ttl, "from", from
+*/
+// 禁止用户加入房间, ttl秒后自动解除
+func setBlock(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	logger.Debugf("islb.setBlock data=%v", data)
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	ttl, _ := data["ttl"].(float64)
+	if ttl <= 0 {
+		return nil, &nprotoo.Error{Code: 412, Reason: fmt.Sprintf("setBlock ttl invalid, data=%v", data)}
+	}
+	bKey := proto.GetUserBlockKey(rid, uid)
+	err := redis.Set(bKey, util.Val(data, "from"), time.Duration(ttl)*time.Second)
+	if err != nil {
+		logger.Errorf("islb.setBlock redis.Set err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 403, Reason: fmt.Sprintf("setBlock err=%v", err)}
+	}
+	return util.Map("rid", rid, "uid", uid, "ttl", ttl), nil
+}
+
+/*
+	"method", proto.BizToIslbGetBlock, "rid", rid, "uid", uid
+*/
+// 查询用户是否被禁止加入房间
+func getBlock(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	bKey := proto.GetUserBlockKey(rid, uid)
+	blocked := redis.Exists(bKey) > 0
+	return util.Map("rid", rid, "uid", uid, "blocked", blocked), nil
+}
+
 /*
 	"method", proto.BizToIslbGetBizInfo, "rid", rid, "uid", uid
 */
@@ -264,6 +421,10 @@ func getRoomUsers(data map[string]interface{}) (map[string]interface{}, *nprotoo
 
 		bizid := redis.Get(key)
 		user := util.Map("rid", rid, "uid", uid, "bizid", bizid)
+		info := redis.Get(proto.GetUserInfoKey(rid, uid))
+		if info != "" {
+			user["info"] = util.Unmarshal(info)
+		}
 		users = append(users, user)
 	}
 	// 返回
@@ -303,3 +464,266 @@ func getRoomPubs(data map[string]interface{}) (map[string]interface{}, *nprotoo.
 	resp := util.Map("pubs", pubs)
 	return resp, nil
 }
+
+// 房间人数和推流人数保存在有序集合中, 成员 = uid, 分数 = 过期时间
+// ARGV = uid, 当前时间, 过期时间, 最大数量(0不限制), ttl
+const countAddScript = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	local max = tonumber(ARGV[4])
+	if max > 0 and redis.call('ZCARD', KEYS[1]) >= max then
+		return -1
+	end
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return redis.call('ZCARD', KEYS[1])
+`
+
+// countAdd 增加计数, 已经存在时只刷新过期时间, 返回当前数量, 超过最大数量返回-1
+func countAdd(key, uid string, ttl time.Duration, max int) (int64, error) {
+	now := time.Now().Unix()
+	res, err := redis.Eval(countAddScript, []string{key}, uid, now, now+int64(ttl.Seconds()), max, int(ttl.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return util.InterfaceToInt64(res), nil
+}
+
+// ARGV = uid, 当前时间
+const countDelScript = `
+local removed = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+if removed == 0 then
+	return -1
+end
+return redis.call('ZCARD', KEYS[1])
+`
+
+// countDel 减少计数, 返回剩余数量, uid不在集合中返回-1
+func countDel(key, uid string) (int64, error) {
+	res, err := redis.Eval(countDelScript, []string{key}, uid, time.Now().Unix())
+	if err != nil {
+		return -1, err
+	}
+	return util.InterfaceToInt64(res), nil
+}
+
+// 房间状态保存在一个hash中, val/$key = 值, ver/$key = 版本号
+// ARGV = key, value, 期望版本号(-1不比较), ttl
+const setStateScript = `
+local ver = tonumber(redis.call('HGET', KEYS[1], 'ver/' .. ARGV[1]) or '0')
+local expect = tonumber(ARGV[3])
+if expect >= 0 and expect ~= ver then
+	return {0, ver}
+end
+ver = ver + 1
+redis.call('HSET', KEYS[1], 'val/' .. ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[1], 'ver/' .. ARGV[1], ver)
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+return {1, ver}
+`
+
+// ARGV = key, 期望版本号(-1不比较)
+const delStateScript = `
+local ver = tonumber(redis.call('HGET', KEYS[1], 'ver/' .. ARGV[1]) or '0')
+local expect = tonumber(ARGV[2])
+if expect >= 0 and expect ~= ver then
+	return {0, ver}
+end
+if ver ~= 0 then
+	redis.call('HDEL', KEYS[1], 'val/' .. ARGV[1], 'ver/' .. ARGV[1])
+end
+return {1, ver}
+`
+
+// stateVersion 获取期望版本号,没有传返回-1
+func stateVersion(data map[string]interface{}) int {
+	if data["version"] == nil {
+		return -1
+	}
+	return util.InterfaceToInt(data["version"])
+}
+
+// evalState 执行状态脚本,返回是否成功和版本号
+func evalState(script string, keys []string, args ...interface{}) (bool, int64, error) {
+	res, err := redis.Eval(script, keys, args...)
+	if err != nil {
+		return false, 0, err
+	}
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("state script result invalid %v", res)
+	}
+	return util.InterfaceToInt64(arr[0]) == 1, util.InterfaceToInt64(arr[1]), nil
+}
+
+/*
+	"method", proto.BizToIslbSetState, "rid", rid, "key", key, "value", value, "version", version(可选)
+*/
+// 设置房间状态
+func setState(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	logger.Debugf("islb.setState data=%v", data)
+	rid := util.Val(data, "rid")
+	key := util.Val(data, "key")
+	value, err := json.Marshal(data["value"])
+	if err != nil {
+		return nil, &nprotoo.Error{Code: 412, Reason: fmt.Sprintf("setState value err=%v", err)}
+	}
+
+	sKey := proto.GetRoomStateKey(rid)
+	ok, ver, err := evalState(setStateScript, []string{sKey}, key, string(value), stateVersion(data), int(redisKeyTTL.Seconds()))
+	if err != nil {
+		logger.Errorf("islb.setState redis.Eval err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 413, Reason: fmt.Sprintf("setState err=%v", err)}
+	}
+	if !ok {
+		return nil, &nprotoo.Error{Code: 414, Reason: fmt.Sprintf("state version conflict, version=%d", ver)}
+	}
+	return util.Map("rid", rid, "key", key, "value", data["value"], "version", ver), nil
+}
+
+/*
+	"method", proto.BizToIslbDelState, "rid", rid, "key", key, "version", version(可选)
+*/
+// 删除房间状态
+func delState(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	logger.Debugf("islb.delState data=%v", data)
+	rid := util.Val(data, "rid")
+	key := util.Val(data, "key")
+
+	sKey := proto.GetRoomStateKey(rid)
+	ok, ver, err := evalState(delStateScript, []string{sKey}, key, stateVersion(data))
+	if err != nil {
+		logger.Errorf("islb.delState redis.Eval err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 413, Reason: fmt.Sprintf("delState err=%v", err)}
+	}
+	if !ok {
+		return nil, &nprotoo.Error{Code: 414, Reason: fmt.Sprintf("state version conflict, version=%d", ver)}
+	}
+	return util.Map("rid", rid, "key", key, "version", ver), nil
+}
+
+/*
+	"method", proto.BizToIslbGetState, "rid", rid, "key", key(可选)
+*/
+// 获取房间状态
+func getState(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	key := util.Val(data, "key")
+
+	state := make(map[string]interface{})
+	sKey := proto.GetRoomStateKey(rid)
+	fields := redis.HGetAll(sKey)
+	for field, val := range fields {
+		if !strings.HasPrefix(field, "val/") {
+			continue
+		}
+		name := strings.TrimPrefix(field, "val/")
+		if key != "" && name != key {
+			continue
+		}
+
+		var value interface{}
+		json.Unmarshal([]byte(val), &value)
+		ver := util.InterfaceToInt64(fields["ver/"+name])
+		state[name] = util.Map("value", value, "version", ver)
+	}
+	return util.Map("state", state), nil
+}
+
+// 房间广播历史保存在有序集合中, 分数 = id, 成员 = {"id", "uid", "ts", "data"}
+// ARGV = uid, ts, data(json), 保存数量, ttl
+const addHistoryScript = `
+local top = redis.call('ZREVRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local id = 1
+if #top > 0 then
+	id = tonumber(top[2]) + 1
+end
+local entry = '{"id":' .. id .. ',"uid":' .. cjson.encode(ARGV[1]) .. ',"ts":' .. ARGV[2] .. ',"data":' .. ARGV[3] .. '}'
+redis.call('ZADD', KEYS[1], id, entry)
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[4]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return id
+`
+
+const (
+	// defaultHistoryLimit 默认获取的广播历史数量
+	defaultHistoryLimit = 50
+)
+
+/*
+	"method", proto.BizToIslbAddHistory, "rid", rid, "uid", uid, "data", data
+*/
+// 保存房间广播, 没有配置保存数量时不保存
+func addHistory(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	if conf.History.Size <= 0 {
+		return util.Map(), nil
+	}
+
+	value, err := json.Marshal(data["data"])
+	if err != nil {
+		return nil, &nprotoo.Error{Code: 412, Reason: fmt.Sprintf("addHistory data err=%v", err)}
+	}
+	ttl := conf.History.TTL
+	if ttl <= 0 {
+		ttl = int(redisKeyTTL.Seconds())
+	}
+
+	ts := time.Now().UnixNano() / int64(time.Millisecond)
+	hKey := proto.GetRoomHistoryKey(rid)
+	res, err := redis.Eval(addHistoryScript, []string{hKey}, uid, ts, string(value), conf.History.Size, ttl)
+	if err != nil {
+		logger.Errorf("islb.addHistory redis.Eval err=%v, data=%v", err, data)
+		return nil, &nprotoo.Error{Code: 413, Reason: fmt.Sprintf("addHistory err=%v", err)}
+	}
+	return util.Map("rid", rid, "uid", uid, "id", util.InterfaceToInt64(res), "ts", ts), nil
+}
+
+/*
+	"method", proto.BizToIslbGetHistory, "rid", rid, "before", before, "after", after, "limit", limit
+*/
+// 获取房间广播历史, 按id从小到大返回
+// 有after时返回after之后的limit条, 否则返回before之前(没有before时为最新)的limit条
+func getHistory(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	rid := util.Val(data, "rid")
+	before := util.InterfaceToInt64(data["before"])
+	after := util.InterfaceToInt64(data["after"])
+	limit := util.InterfaceToInt64(data["limit"])
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if size := int64(conf.History.Size); limit > size {
+		limit = size
+	}
+
+	messages := make([]interface{}, 0)
+	if limit <= 0 {
+		return util.Map("rid", rid, "messages", messages), nil
+	}
+
+	min, max := "-inf", "+inf"
+	if after > 0 {
+		min = "(" + strconv.FormatInt(after, 10)
+	}
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+
+	hKey := proto.GetRoomHistoryKey(rid)
+	var entries []string
+	if after > 0 {
+		entries = redis.ZRangeByScore(hKey, min, max, limit)
+	} else {
+		entries = redis.ZRevRangeByScore(hKey, max, min, limit)
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	for _, entry := range entries {
+		messages = append(messages, util.Unmarshal(entry))
+	}
+	return util.Map("rid", rid, "messages", messages), nil
+}
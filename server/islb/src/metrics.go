@@ -0,0 +1,33 @@
+package src
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	nprotoo "github.com/zhuanxin-sz/nats-protoo"
+)
+
+var (
+	// metricRequests rpc请求数量, result = ok/error
+	metricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "islb_requests_total",
+		Help: "RPC requests handled, by method and result.",
+	}, []string{"method", "result"})
+	// metricRequestSeconds rpc请求耗时
+	metricRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "islb_request_duration_seconds",
+		Help:    "RPC request latency, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// observeRequest 统计rpc请求结果和耗时
+func observeRequest(method string, start time.Time, err *nprotoo.Error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metricRequests.WithLabelValues(method, result).Inc()
+	metricRequestSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
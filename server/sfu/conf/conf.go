@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -21,6 +22,10 @@ var (
 )
 
 func init() {
+	// go test时没有命令行参数和配置文件,使用空配置
+	if strings.HasSuffix(strings.TrimSuffix(os.Args[0], ".exe"), ".test") {
+		return
+	}
 	if !cfg.parse() {
 		showHelp()
 		os.Exit(-1)
@@ -51,6 +56,7 @@ type iceserver struct {
 type webrtc struct {
 	ICEPortRange []uint16    `mapstructure:"portrange"`
 	ICEServers   []iceserver `mapstructure:"iceserver"`
+	Codecs       []string    `mapstructure:"codecs"`
 }
 
 type config struct {
@@ -0,0 +1,125 @@
+package rtc
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	// rateCycle 统计每层推流码率的周期
+	rateCycle = time.Second
+	// lossDown 丢包率超过时降层
+	lossDown = 0.1
+	// lossUp 丢包率低于时才允许升层
+	lossUp = 0.02
+	// lossWeight 新丢包率的平滑权重
+	lossWeight = 0.3
+	// downRatio 带宽估计低于当前层码率的比例时降层
+	downRatio = 0.85
+	// upRatio 带宽估计高于上一层码率的比例时升层
+	upRatio = 1.1
+	// probeRatio 带宽估计高于当前层码率的比例时尝试升层,接收端的估计不会比收到的码率高太多
+	probeRatio = 1.3
+	// minProbeDelay 降层后等待多久再尝试升层
+	minProbeDelay = 5 * time.Second
+	// maxProbeDelay 升层失败多次后最长的等待时间
+	maxProbeDelay = 60 * time.Second
+	// probeWindow 升层后这段时间内降层视为升层失败
+	probeWindow = 10 * time.Second
+	// estimateTimeout 带宽估计超过这个时间没有更新时不再使用
+	estimateTimeout = 5 * time.Second
+)
+
+// layerControl 根据sub反馈的带宽估计和丢包选择simulcast层,降层要快,升层要慢
+type layerControl struct {
+	bitrate    uint64
+	bitrateAt  time.Time
+	loss       float64
+	probeDelay time.Duration
+	changeAt   time.Time
+	upAt       time.Time
+}
+
+// newLayerControl 创建layerControl对象
+func newLayerControl() *layerControl {
+	return &layerControl{
+		probeDelay: minProbeDelay,
+		changeAt:   time.Now(),
+	}
+}
+
+// Feedback 记录sub的RTCP反馈,返回是否需要重新选择层
+func (c *layerControl) Feedback(pkt rtcp.Packet, ssrc uint32) bool {
+	switch p := pkt.(type) {
+	case *rtcp.ReceiverEstimatedMaximumBitrate:
+		c.bitrate = p.Bitrate
+		c.bitrateAt = time.Now()
+		return true
+	case *rtcp.ReceiverReport:
+		for _, report := range p.Reports {
+			if report.SSRC == ssrc {
+				loss := float64(report.FractionLost) / 256
+				c.loss = c.loss*(1-lossWeight) + loss*lossWeight
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Estimate 返回有效的带宽估计,没有时为0
+func (c *layerControl) Estimate() uint64 {
+	if time.Since(c.bitrateAt) > estimateTimeout {
+		return 0
+	}
+	return c.bitrate
+}
+
+// Select 根据每层的码率选择层,不超过pref,rates为0的层没有在推流
+func (c *layerControl) Select(current, pref int, rates []uint64) int {
+	now := time.Now()
+	bitrate := c.Estimate()
+
+	// 拥塞或当前层停止推流时立刻降层,选能放下的最高层
+	paused := rates[current] == 0 && rates[0] > 0
+	if current > 0 && (paused || c.loss > lossDown || (bitrate > 0 && float64(bitrate) < float64(rates[current])*downRatio)) {
+		layer := current - 1
+		for layer > 0 && (rates[layer] == 0 || (bitrate > 0 && float64(bitrate) < float64(rates[layer])*downRatio)) {
+			layer--
+		}
+		// 刚升层就拥塞,说明带宽不够,下次等更久再升
+		if now.Sub(c.upAt) < probeWindow {
+			c.probeDelay *= 2
+			if c.probeDelay > maxProbeDelay {
+				c.probeDelay = maxProbeDelay
+			}
+		}
+		// 丢包率是上一层的,切换后重新统计
+		c.loss = 0
+		c.changeAt = now
+		return layer
+	}
+
+	// 稳定一段时间后每次只升一层
+	if current >= pref || c.loss > lossUp || now.Sub(c.changeAt) < c.probeDelay {
+		return current
+	}
+	// 没有带宽估计时不升层
+	next := rates[current+1]
+	if next == 0 || bitrate == 0 {
+		return current
+	}
+	if float64(bitrate) >= float64(next)*upRatio || float64(bitrate) >= float64(rates[current])*probeRatio {
+		c.loss = 0
+		c.changeAt = now
+		c.upAt = now
+		return current + 1
+	}
+
+	// 升层后稳定下来,恢复探测间隔
+	if now.Sub(c.upAt) > maxProbeDelay {
+		c.probeDelay = minProbeDelay
+	}
+	return current
+}
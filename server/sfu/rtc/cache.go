@@ -0,0 +1,79 @@
+package rtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	// cacheSize 每个track缓存的RTP包数量
+	cacheSize = 1024
+	// nackCycle 同一个包向pub请求重传的最小间隔
+	nackCycle = 100 * time.Millisecond
+)
+
+// packetCache 按序号缓存最近的RTP包,用于回复sub的NACK
+type packetCache struct {
+	lock   sync.Mutex
+	pkts   [cacheSize]*rtp.Packet
+	nacked [cacheSize]uint16
+	nackAt [cacheSize]time.Time
+}
+
+// newPacketCache 创建packetCache对象
+func newPacketCache() *packetCache {
+	return &packetCache{}
+}
+
+// Push 缓存RTP包,包被所有sub共用,不能修改
+func (c *packetCache) Push(pkt *rtp.Packet) {
+	c.lock.Lock()
+	c.pkts[pkt.SequenceNumber%cacheSize] = pkt
+	c.lock.Unlock()
+}
+
+// Get 根据序号获取缓存的包,已经被覆盖时返回nil
+func (c *packetCache) Get(seq uint16) *rtp.Packet {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pkt := c.pkts[seq%cacheSize]
+	if pkt == nil || pkt.SequenceNumber != seq {
+		return nil
+	}
+	return pkt
+}
+
+// NackOnce 判断是否需要向pub请求重传,多个sub同时丢包时nackCycle内只请求一次
+func (c *packetCache) NackOnce(seq uint16) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index := seq % cacheSize
+	if c.nacked[index] == seq && time.Since(c.nackAt[index]) < nackCycle {
+		return false
+	}
+	c.nacked[index] = seq
+	c.nackAt[index] = time.Now()
+	return true
+}
+
+// nackPairs 把序号合并成NACK的PacketID和LostPackets,重复的序号只请求一次
+func nackPairs(seqs []uint16) []rtcp.NackPair {
+	pairs := make([]rtcp.NackPair, 0)
+	for _, seq := range seqs {
+		if n := len(pairs); n > 0 {
+			diff := seq - pairs[n-1].PacketID
+			if diff == 0 {
+				continue
+			}
+			if diff <= 16 {
+				pairs[n-1].LostPackets |= 1 << (diff - 1)
+				continue
+			}
+		}
+		pairs = append(pairs, rtcp.NackPair{PacketID: seq})
+	}
+	return pairs
+}
@@ -0,0 +1,113 @@
+package rtc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func TestNackPairs(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint16
+		want []rtcp.NackPair
+	}{
+		{"empty", []uint16{}, []rtcp.NackPair{}},
+		{"single", []uint16{100}, []rtcp.NackPair{{PacketID: 100}}},
+		{"consecutive", []uint16{100, 101, 102}, []rtcp.NackPair{{PacketID: 100, LostPackets: 0x0003}}},
+		{"last bit", []uint16{100, 116}, []rtcp.NackPair{{PacketID: 100, LostPackets: 0x8000}}},
+		{"next pair", []uint16{100, 117}, []rtcp.NackPair{{PacketID: 100}, {PacketID: 117}}},
+		{"gaps", []uint16{100, 103, 110, 130, 131}, []rtcp.NackPair{
+			{PacketID: 100, LostPackets: 0x0204},
+			{PacketID: 130, LostPackets: 0x0001},
+		}},
+		{"wrap", []uint16{65534, 65535, 0, 1}, []rtcp.NackPair{{PacketID: 65534, LostPackets: 0x0007}}},
+		{"wrap next pair", []uint16{65530, 11}, []rtcp.NackPair{{PacketID: 65530}, {PacketID: 11}}},
+		{"duplicate", []uint16{100, 100, 101, 101}, []rtcp.NackPair{{PacketID: 100, LostPackets: 0x0001}}},
+	}
+
+	for _, tt := range tests {
+		got := nackPairs(tt.seqs)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: nackPairs(%v) = %v, want %v", tt.name, tt.seqs, got, tt.want)
+			continue
+		}
+		// 解析回序号列表应该和去重后的输入一致
+		seqs := make([]uint16, 0)
+		for _, pair := range got {
+			seqs = append(seqs, pair.PacketList()...)
+		}
+		want := make([]uint16, 0)
+		for i, seq := range tt.seqs {
+			if i == 0 || seq != tt.seqs[i-1] {
+				want = append(want, seq)
+			}
+		}
+		if !reflect.DeepEqual(seqs, want) {
+			t.Errorf("%s: packet list = %v, want %v", tt.name, seqs, want)
+		}
+	}
+}
+
+func TestPacketCache(t *testing.T) {
+	c := newPacketCache()
+	for _, seq := range []uint16{65534, 65535, 0, 1} {
+		c.Push(testPacket(1, seq, uint32(seq)))
+	}
+
+	tests := []struct {
+		seq  uint16
+		want bool
+	}{
+		{65534, true},
+		{65535, true},
+		{0, true},
+		{1, true},
+		{2, false},
+		{1 + cacheSize, false},
+	}
+	for _, tt := range tests {
+		pkt := c.Get(tt.seq)
+		if (pkt != nil) != tt.want || (pkt != nil && pkt.SequenceNumber != tt.seq) {
+			t.Errorf("get(%d) = %v, want %v", tt.seq, pkt, tt.want)
+		}
+	}
+
+	// 同一个位置的新包覆盖老包
+	c.Push(testPacket(1, 1+cacheSize, 0))
+	if c.Get(1) != nil {
+		t.Errorf("get(1) after overwrite should be nil")
+	}
+	if c.Get(1+cacheSize) == nil {
+		t.Errorf("get(%d) after overwrite should not be nil", 1+cacheSize)
+	}
+}
+
+func TestPacketCacheNackOnce(t *testing.T) {
+	c := newPacketCache()
+	if !c.NackOnce(100) {
+		t.Errorf("first nack(100) should be sent")
+	}
+	if c.NackOnce(100) {
+		t.Errorf("second nack(100) within nackCycle should be skipped")
+	}
+	if !c.NackOnce(101) {
+		t.Errorf("nack(101) should be sent")
+	}
+
+	// 同一个位置的其他序号不受影响
+	if !c.NackOnce(100 + cacheSize) {
+		t.Errorf("nack(%d) should be sent", 100+cacheSize)
+	}
+	if !c.NackOnce(100) {
+		t.Errorf("nack(100) after slot reuse should be sent")
+	}
+
+	// 超过nackCycle后再次请求
+	c.nackAt[101%cacheSize] = time.Now().Add(-nackCycle)
+	if !c.NackOnce(101) {
+		t.Errorf("nack(101) after nackCycle should be sent")
+	}
+}
@@ -0,0 +1,236 @@
+package rtc
+
+import (
+	"fmt"
+	"server/server/sfu/conf"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+	"github.com/zhuanxin-sz/go-protoo/logger"
+)
+
+const (
+	// codecAV1 pion没有定义AV1
+	codecAV1 = "AV1"
+
+	// h264ProfileBaseline profile-level-id中的profile_idc
+	h264ProfileBaseline = 0x42
+	// h264ConstraintSet1 profile-level-id中constrained baseline的标记位
+	h264ConstraintSet1 = 0x40
+)
+
+var (
+	// supportCodecs 支持的编码
+	supportCodecs = []string{webrtc.Opus, webrtc.VP8, webrtc.VP9, webrtc.H264, codecAV1}
+	// defaultCodecs 没有配置时使用的编码,按优先级排序
+	defaultCodecs = []string{webrtc.Opus, webrtc.VP8, webrtc.H264}
+	// codecNames 配置的编码,按优先级排序
+	codecNames []string
+	// pubFeedback 推流视频协商的rtcp-fb,不协商goog-remb,避免推流端等待sfu的带宽估计
+	pubFeedback = []string{"nack", "nack pli"}
+	// subFeedback 拉流视频协商的rtcp-fb,goog-remb用于按带宽选择simulcast层
+	// pion不支持transport-wide-cc扩展头,不协商transport-cc
+	subFeedback = []string{"nack", "nack pli", "goog-remb"}
+)
+
+// initCodecs 读取配置的编码,忽略不支持的编码
+func initCodecs() {
+	codecNames = make([]string, 0)
+	for _, name := range conf.WebRTC.Codecs {
+		found := false
+		for _, codec := range supportCodecs {
+			if strings.EqualFold(name, codec) {
+				codecNames = append(codecNames, codec)
+				found = true
+				break
+			}
+		}
+		if !found {
+			logger.Errorf("rtc codec not support = %s", name)
+		}
+	}
+	if len(codecNames) == 0 {
+		codecNames = defaultCodecs
+	}
+}
+
+// newCodec 创建编码对象,使用offer中的payload type,视频带上offer和feedback都有的rtcp-fb
+func newCodec(name string, offer sdp.Codec, feedback []string) *webrtc.RTPCodec {
+	codec := newCodecByName(name, offer)
+	if codec != nil && codec.Type == webrtc.RTPCodecTypeVideo {
+		codec.RTCPFeedback = matchFeedback(offer.RTCPFeedback, feedback)
+	}
+	return codec
+}
+
+// matchFeedback 返回offer中支持的rtcp-fb
+func matchFeedback(offer []string, feedback []string) []webrtc.RTCPFeedback {
+	result := make([]webrtc.RTCPFeedback, 0)
+	for _, fb := range offer {
+		for _, support := range feedback {
+			if !strings.EqualFold(fb, support) {
+				continue
+			}
+			kv := strings.SplitN(support, " ", 2)
+			item := webrtc.RTCPFeedback{Type: kv[0]}
+			if len(kv) == 2 {
+				item.Parameter = kv[1]
+			}
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// newCodecByName 根据编码名称创建编码对象
+func newCodecByName(name string, offer sdp.Codec) *webrtc.RTPCodec {
+	switch name {
+	case webrtc.Opus:
+		return webrtc.NewRTPOpusCodec(offer.PayloadType, offer.ClockRate)
+	case webrtc.VP8:
+		return webrtc.NewRTPVP8Codec(offer.PayloadType, offer.ClockRate)
+	case webrtc.VP9:
+		codec := webrtc.NewRTPVP9Codec(offer.PayloadType, offer.ClockRate)
+		codec.SDPFmtpLine = offer.Fmtp
+		return codec
+	case webrtc.H264:
+		// profile-level-id需要和offer一致
+		codec := webrtc.NewRTPH264Codec(offer.PayloadType, offer.ClockRate)
+		codec.SDPFmtpLine = offer.Fmtp
+		return codec
+	case codecAV1:
+		return webrtc.NewRTPCodec(webrtc.RTPCodecTypeVideo, codecAV1, offer.ClockRate, 0, offer.Fmtp, offer.PayloadType, &forwardPayloader{})
+	}
+	return nil
+}
+
+// forwardPayloader 只转发RTP包的编码使用,sfu不需要打包
+type forwardPayloader struct{}
+
+// Payload 不支持打包,返回空
+func (p *forwardPayloader) Payload(mtu int, payload []byte) [][]byte {
+	return nil
+}
+
+// matchCodec 判断offer中的编码是否可用
+// H264只支持constrained baseline和packetization-mode=1, VP9和AV1只支持profile 0
+func matchCodec(name string, offer sdp.Codec) bool {
+	if !strings.EqualFold(name, offer.Name) {
+		return false
+	}
+	switch name {
+	case webrtc.H264:
+		return h264Supported(offer.Fmtp)
+	case webrtc.VP9:
+		return fmtpValue(offer.Fmtp, "profile-id", "0") == "0"
+	case codecAV1:
+		return fmtpValue(offer.Fmtp, "profile", "0") == "0"
+	}
+	return true
+}
+
+// fmtpValue 获取fmtp中的参数,没有时返回def
+func fmtpValue(fmtp, key, def string) string {
+	for _, param := range strings.Split(fmtp, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], key) {
+			return kv[1]
+		}
+	}
+	return def
+}
+
+// h264Supported 解析fmtp判断是否为constrained baseline和packetization-mode=1
+func h264Supported(fmtp string) bool {
+	mode := fmtpValue(fmtp, "packetization-mode", "0")
+	profile := fmtpValue(fmtp, "profile-level-id", "")
+	if mode != "1" || len(profile) != 6 {
+		return false
+	}
+	idc, err := strconv.ParseUint(profile[0:2], 16, 8)
+	if err != nil {
+		return false
+	}
+	iop, err := strconv.ParseUint(profile[2:4], 16, 8)
+	if err != nil {
+		return false
+	}
+	return idc == h264ProfileBaseline && iop&h264ConstraintSet1 != 0
+}
+
+// offerCodecs 解析offer中的所有编码,按offer中的顺序
+func offerCodecs(offer webrtc.SessionDescription) ([]sdp.Codec, error) {
+	desc := sdp.SessionDescription{}
+	err := desc.Unmarshal([]byte(offer.SDP))
+	if err != nil {
+		return nil, err
+	}
+
+	codecs := make([]sdp.Codec, 0)
+	for _, media := range desc.MediaDescriptions {
+		for _, format := range media.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := desc.GetCodecForPayloadType(uint8(pt))
+			if err != nil {
+				continue
+			}
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs, nil
+}
+
+// findCodec 查找offer中第一个可用的编码
+func findCodec(name string, codecs []sdp.Codec, feedback []string) (*webrtc.RTPCodec, bool) {
+	for _, codec := range codecs {
+		if matchCodec(name, codec) {
+			return newCodec(name, codec, feedback), true
+		}
+	}
+	return nil, false
+}
+
+// newPubEngine 推流的MediaEngine,按配置的优先级注册offer中支持的编码
+func newPubEngine(offer webrtc.SessionDescription) (*webrtc.MediaEngine, error) {
+	codecs, err := offerCodecs(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &webrtc.MediaEngine{}
+	count := 0
+	for _, name := range codecNames {
+		codec, ok := findCodec(name, codecs, pubFeedback)
+		if ok {
+			engine.RegisterCodec(codec)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("offer has no supported codec, support %v", codecNames)
+	}
+	return engine, nil
+}
+
+// newSubEngine 拉流的MediaEngine,只注册推流使用的编码,offer中没有时返回错误
+func newSubEngine(offer webrtc.SessionDescription, pubCodecs []*webrtc.RTPCodec) (*webrtc.MediaEngine, error) {
+	codecs, err := offerCodecs(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &webrtc.MediaEngine{}
+	for _, pubCodec := range pubCodecs {
+		codec, ok := findCodec(pubCodec.Name, codecs, subFeedback)
+		if !ok {
+			return nil, fmt.Errorf("subscriber can't decode %s codec %s", pubCodec.Type, pubCodec.Name)
+		}
+		engine.RegisterCodec(codec)
+	}
+	return engine, nil
+}
@@ -0,0 +1,138 @@
+package rtc
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// h264 nal类型
+const (
+	h264NaluIDR   = 5
+	h264NaluSPS   = 7
+	h264NaluSTAPA = 24
+	h264NaluFUA   = 28
+)
+
+// isKeyframe 根据编码的payload descriptor判断是否为关键帧的第一个包
+func isKeyframe(codec string, payload []byte) bool {
+	switch {
+	case strings.EqualFold(codec, webrtc.VP8):
+		return vp8Keyframe(payload)
+	case strings.EqualFold(codec, webrtc.VP9):
+		return vp9Keyframe(payload)
+	case strings.EqualFold(codec, webrtc.H264):
+		return h264Keyframe(payload)
+	case strings.EqualFold(codec, codecAV1):
+		return av1Keyframe(payload)
+	}
+	return false
+}
+
+// vp8Keyframe RFC7741, S=1且PID=0时解析VP8帧头, P=0为关键帧
+func vp8Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	// X|R|N|S|R|PID
+	if payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		return false
+	}
+	offset := 1
+	if payload[0]&0x80 != 0 {
+		// I|L|T|K|RSV
+		if len(payload) < 2 {
+			return false
+		}
+		ext := payload[1]
+		offset++
+		if ext&0x80 != 0 {
+			if len(payload) <= offset {
+				return false
+			}
+			// M=1时picture id为2个字节
+			if payload[offset]&0x80 != 0 {
+				offset++
+			}
+			offset++
+		}
+		if ext&0x40 != 0 {
+			offset++
+		}
+		if ext&0x20 != 0 || ext&0x10 != 0 {
+			offset++
+		}
+	}
+	if len(payload) <= offset {
+		return false
+	}
+	return payload[offset]&0x01 == 0
+}
+
+// vp9Keyframe draft-ietf-payload-vp9, P=0且B=1, 有空间层时只看第一层
+func vp9Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	// I|P|L|F|B|E|V|Z
+	desc := payload[0]
+	if desc&0x40 != 0 || desc&0x08 == 0 {
+		return false
+	}
+	offset := 1
+	if desc&0x80 != 0 {
+		if len(payload) <= offset {
+			return false
+		}
+		if payload[offset]&0x80 != 0 {
+			offset++
+		}
+		offset++
+	}
+	if desc&0x20 != 0 {
+		// TID|U|SID|D
+		if len(payload) <= offset {
+			return false
+		}
+		return (payload[offset]>>1)&0x07 == 0
+	}
+	return true
+}
+
+// h264Keyframe RFC6184, IDR或SPS, 支持STAP-A和FU-A
+func h264Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	nalu := payload[0] & 0x1F
+	switch nalu {
+	case h264NaluIDR, h264NaluSPS:
+		return true
+	case h264NaluSTAPA:
+		offset := 1
+		for offset+2 < len(payload) {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			sub := payload[offset] & 0x1F
+			if sub == h264NaluIDR || sub == h264NaluSPS {
+				return true
+			}
+			offset += size
+		}
+	case h264NaluFUA:
+		// S|E|R|Type
+		if len(payload) < 2 {
+			return false
+		}
+		return payload[1]&0x80 != 0 && payload[1]&0x1F == h264NaluIDR
+	}
+	return false
+}
+
+// av1Keyframe AV1 RTP规范, 聚合头Z|Y|W|N, N=1为新的编码序列的第一个包
+func av1Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	return payload[0]&0x08 != 0
+}
@@ -0,0 +1,69 @@
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+)
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   string
+		payload []byte
+		want    bool
+	}{
+		// VP8, X|S, I|L|T, 2字节picture id, TL0PICIDX, TID|Y|KEYIDX, 帧头
+		{"vp8 keyframe chrome", webrtc.VP8, []byte{0x90, 0xe0, 0x80, 0x01, 0x0f, 0x40, 0x50, 0x42, 0x00, 0x9d, 0x01, 0x2a}, true},
+		{"vp8 delta chrome", webrtc.VP8, []byte{0x90, 0xe0, 0x80, 0x02, 0x10, 0x80, 0x31, 0x08, 0x00}, false},
+		{"vp8 keyframe 1 byte picture id", webrtc.VP8, []byte{0x90, 0x80, 0x15, 0x50, 0x42}, true},
+		{"vp8 keyframe no extension", webrtc.VP8, []byte{0x10, 0x50, 0x42}, true},
+		{"vp8 delta no extension", webrtc.VP8, []byte{0x10, 0x51, 0x42}, false},
+		{"vp8 not first packet", webrtc.VP8, []byte{0x80, 0x80, 0x80, 0x01, 0x50}, false},
+		{"vp8 not first partition", webrtc.VP8, []byte{0x11, 0x50}, false},
+		{"vp8 truncated picture id", webrtc.VP8, []byte{0x90, 0x80, 0x80}, false},
+		{"vp8 truncated frame header", webrtc.VP8, []byte{0x90, 0xe0, 0x80, 0x01, 0x0f, 0x40}, false},
+		{"vp8 empty", webrtc.VP8, []byte{}, false},
+
+		// VP9, I|P|L|F|B|E|V|Z
+		{"vp9 keyframe chrome", webrtc.VP9, []byte{0x8e, 0x80, 0x01, 0x18, 0x02, 0x80, 0x01, 0x68}, true},
+		{"vp9 keyframe no picture id", webrtc.VP9, []byte{0x08, 0x82}, true},
+		{"vp9 delta", webrtc.VP9, []byte{0xcc, 0x80, 0x02, 0x86}, false},
+		{"vp9 not first packet", webrtc.VP9, []byte{0x84, 0x80, 0x01}, false},
+		{"vp9 keyframe spatial layer 0", webrtc.VP9, []byte{0xac, 0x80, 0x01, 0x00, 0x00}, true},
+		{"vp9 keyframe spatial layer 1", webrtc.VP9, []byte{0xac, 0x80, 0x01, 0x02, 0x00}, false},
+		{"vp9 truncated picture id", webrtc.VP9, []byte{0x88}, false},
+		{"vp9 truncated layer", webrtc.VP9, []byte{0xa8, 0x01}, false},
+
+		// H264, nal头F|NRI|Type
+		{"h264 idr", webrtc.H264, []byte{0x65, 0x88, 0x84, 0x00}, true},
+		{"h264 sps", webrtc.H264, []byte{0x67, 0x42, 0xc0, 0x1f}, true},
+		{"h264 pps", webrtc.H264, []byte{0x68, 0xce, 0x3c, 0x80}, false},
+		{"h264 non idr", webrtc.H264, []byte{0x41, 0x9a, 0x02}, false},
+		{"h264 stap-a sps pps", webrtc.H264, []byte{0x78, 0x00, 0x04, 0x67, 0x42, 0xc0, 0x1f, 0x00, 0x02, 0x68, 0xce}, true},
+		{"h264 stap-a idr second", webrtc.H264, []byte{0x78, 0x00, 0x02, 0x06, 0x05, 0x00, 0x02, 0x65, 0x88}, true},
+		{"h264 stap-a non idr", webrtc.H264, []byte{0x78, 0x00, 0x02, 0x41, 0x9a, 0x00, 0x02, 0x41, 0x9b}, false},
+		{"h264 stap-a truncated", webrtc.H264, []byte{0x78, 0x00}, false},
+		{"h264 fu-a idr start", webrtc.H264, []byte{0x7c, 0x85, 0xb8, 0x00}, true},
+		{"h264 fu-a idr middle", webrtc.H264, []byte{0x7c, 0x05, 0xb8, 0x00}, false},
+		{"h264 fu-a non idr start", webrtc.H264, []byte{0x5c, 0x81, 0x9a, 0x00}, false},
+		{"h264 fu-a truncated", webrtc.H264, []byte{0x7c}, false},
+		{"h264 empty", webrtc.H264, []byte{}, false},
+
+		// AV1, 聚合头Z|Y|W|N
+		{"av1 new sequence", codecAV1, []byte{0x18, 0x0a, 0x0b}, true},
+		{"av1 continue", codecAV1, []byte{0x10, 0x32, 0x00}, false},
+		{"av1 fragment", codecAV1, []byte{0xc0, 0x00}, false},
+		{"av1 empty", codecAV1, []byte{}, false},
+
+		// 编码名称不区分大小写
+		{"lower case codec", "vp8", []byte{0x10, 0x50}, true},
+		{"unknown codec", webrtc.Opus, []byte{0x10, 0x50}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isKeyframe(tt.codec, tt.payload); got != tt.want {
+			t.Errorf("%s: isKeyframe(%s, % x) = %v, want %v", tt.name, tt.codec, tt.payload, got, tt.want)
+		}
+	}
+}
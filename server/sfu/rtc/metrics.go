@@ -0,0 +1,84 @@
+package rtc
+
+import (
+	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// dropMute 静音时丢弃
+	dropMute = "mute"
+	// dropWrite 写sub失败丢弃
+	dropWrite = "write"
+	// dropKeyframe 等待关键帧时丢弃
+	dropKeyframe = "keyframe"
+)
+
+var (
+	// metricPackets 转发给sub的RTP包数量
+	metricPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_rtp_packets_total",
+		Help: "RTP packets forwarded to subscribers, by kind.",
+	}, []string{"kind"})
+	// metricBytes 转发给sub的RTP字节数
+	metricBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_rtp_bytes_total",
+		Help: "RTP bytes forwarded to subscribers, by kind.",
+	}, []string{"kind"})
+	// metricDropped 没有转发的RTP包数量
+	metricDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_rtp_dropped_total",
+		Help: "RTP packets not forwarded to subscribers, by kind and reason.",
+	}, []string{"kind", "reason"})
+
+	// metricLayerSwitch 按带宽自动切换simulcast层的次数
+	metricLayerSwitch = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_layer_switches_total",
+		Help: "Automatic simulcast layer switches for subscribers, by direction.",
+	}, []string{"direction"})
+
+	// metricNackCache sub的NACK在缓存中找到和没找到的包数量
+	metricNackCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_nack_cache_total",
+		Help: "Packets requested by subscriber NACKs, by cache result.",
+	}, []string{"result"})
+	// metricNackUpstream 向pub请求重传的包数量
+	metricNackUpstream = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sfu_nack_upstream_total",
+		Help: "Packets requested from publishers after a cache miss.",
+	})
+
+	audioPackets = metricPackets.WithLabelValues(webrtc.RTPCodecTypeAudio.String())
+	videoPackets = metricPackets.WithLabelValues(webrtc.RTPCodecTypeVideo.String())
+	audioBytes   = metricBytes.WithLabelValues(webrtc.RTPCodecTypeAudio.String())
+	videoBytes   = metricBytes.WithLabelValues(webrtc.RTPCodecTypeVideo.String())
+	layerUp      = metricLayerSwitch.WithLabelValues("up")
+	layerDown    = metricLayerSwitch.WithLabelValues("down")
+	nackHit      = metricNackCache.WithLabelValues("hit")
+	nackMiss     = metricNackCache.WithLabelValues("miss")
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sfu_routers",
+		Help: "Number of routers on this node.",
+	}, func() float64 {
+		routers, _, _ := GetCounts()
+		return float64(routers)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sfu_pubs",
+		Help: "Number of publishers on this node.",
+	}, func() float64 {
+		_, pubs, _ := GetCounts()
+		return float64(pubs)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sfu_subs",
+		Help: "Number of subscribers on this node.",
+	}, func() float64 {
+		_, _, subs := GetCounts()
+		return float64(subs)
+	})
+}
@@ -12,6 +12,8 @@ import (
 
 const (
 	liveCycle = 6 * time.Second
+	// pliCycle 向pub请求关键帧的最小间隔
+	pliCycle = 500 * time.Millisecond
 )
 
 // Router 对象
@@ -23,6 +25,14 @@ type Router struct {
 	subsLock   sync.Mutex
 	audioAlive time.Time
 	videoAlive time.Time
+	audioMute  bool
+	videoMute  bool
+	pliTime    [maxLayers]time.Time
+	pliLock    sync.Mutex
+	videoCache [maxLayers]*packetCache
+	layerBytes [maxLayers]uint64
+	layerRates [maxLayers]uint64
+	rateTime   time.Time
 }
 
 // NewRouter 创建Router对象
@@ -35,18 +45,21 @@ func NewRouter(id string) *Router {
 		audioAlive: time.Now().Add(liveCycle),
 		videoAlive: time.Now().Add(liveCycle),
 	}
+	for i := range router.videoCache {
+		router.videoCache[i] = newPacketCache()
+	}
 	return router
 }
 
 // AddPub 增加Pub对象
 func (router *Router) AddPub(mid, sdp string) (string, error) {
-	pub, err := NewPub(mid)
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	pub, err := NewPub(mid, offer)
 	if err != nil {
 		logger.Errorf("router add pub err=%v, id=%s, mid=%s", err, router.Id, mid)
 		return "", err
 	}
 
-	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
 	answer, err := pub.Answer(offer)
 	if err != nil {
 		logger.Errorf("router pub answer err=%v, id=%s, mid=%s", err, router.Id, mid)
@@ -54,6 +67,12 @@ func (router *Router) AddPub(mid, sdp string) (string, error) {
 		return "", err
 	}
 
+	// 协商期间router可能已经被清理
+	if router.stop {
+		pub.Close()
+		return "", errors.New("router is closed")
+	}
+
 	logger.Debugf("router add pub = %s", pub.Id)
 
 	router.pub = pub
@@ -63,9 +82,14 @@ func (router *Router) AddPub(mid, sdp string) (string, error) {
 	return answer.SDP, nil
 }
 
-// AddSub 增加Sub对象
+// AddSub 增加Sub对象,sub不支持推流的编码时返回错误
 func (router *Router) AddSub(sid, sdp string) (string, error) {
-	sub, err := NewSub(sid)
+	if router.pub == nil {
+		return "", errors.New("router has no pub")
+	}
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	sub, err := NewSub(sid, offer, router.pub.Codecs())
 	if err != nil {
 		logger.Errorf("router add sub err=%v, id=%s, sid=%s", err, router.Id, sid)
 		return "", err
@@ -102,7 +126,6 @@ func (router *Router) AddSub(sid, sdp string) (string, error) {
 		return "", errors.New("router sub no audio and video track")
 	}
 
-	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
 	answer, err := sub.Answer(offer)
 	if err != nil {
 		logger.Errorf("router sub offer err=%v, id=%s, sid=%s", err, router.Id, sid)
@@ -112,10 +135,21 @@ func (router *Router) AddSub(sid, sdp string) (string, error) {
 
 	logger.Debugf("router add sub = %s", sub.Id)
 
+	// 新的sub从中间层的关键帧开始转发,带宽足够时再逐层升层
+	// 在加入subs之前设置,避免DoVideoWork按第0层开始转发
+	layer := (router.pub.LayerCount() - 1) / 2
+	if sub.TrackVideo != nil {
+		sub.layer, sub.targetLayer, sub.prefLayer = layer, layer, router.pub.LayerCount()-1
+	}
+
 	router.subsLock.Lock()
 	router.subs[sid] = sub
 	router.subsLock.Unlock()
 
+	if sub.TrackVideo != nil {
+		router.RequestKeyframe(layer)
+	}
+
 	// 启动RTCP处理线程
 	go router.DoRTCPWork(sub)
 	return answer.SDP, nil
@@ -149,6 +183,131 @@ func (router *Router) GetSubs() map[string]*Sub {
 	return router.subs
 }
 
+// GetSubCount 获取subs数量
+func (router *Router) GetSubCount() int {
+	router.subsLock.Lock()
+	defer router.subsLock.Unlock()
+	return len(router.subs)
+}
+
+// SetMute 停止或恢复转发音频/视频,kind = audio/video
+func (router *Router) SetMute(kind string, mute bool) error {
+	switch kind {
+	case webrtc.RTPCodecTypeAudio.String():
+		router.audioMute = mute
+	case webrtc.RTPCodecTypeVideo.String():
+		// 恢复转发时sub从关键帧开始
+		if !mute {
+			router.subsLock.Lock()
+			for _, sub := range router.subs {
+				sub.waitKeyframe = true
+			}
+			router.subsLock.Unlock()
+		}
+		router.videoMute = mute
+		if pub := router.pub; !mute && pub != nil {
+			for layer := 0; layer < pub.LayerCount(); layer++ {
+				router.RequestKeyframe(layer)
+			}
+		}
+	default:
+		return errors.New("router mute kind invalid")
+	}
+	return nil
+}
+
+// SetLayer 设置sub希望接收的simulcast层,0为最低层,超过推流层数时使用最高层,返回实际的层
+// 降层立即生效,升层在带宽足够时逐层进行,sub没有带宽估计时直接切换
+func (router *Router) SetLayer(sid string, layer int) (int, error) {
+	pub := router.pub
+	if pub == nil {
+		return 0, errors.New("router has no pub")
+	}
+	if layer < 0 {
+		return 0, errors.New("router layer invalid")
+	}
+	if layer >= pub.LayerCount() {
+		layer = pub.LayerCount() - 1
+	}
+
+	router.subsLock.Lock()
+	sub := router.subs[sid]
+	if sub == nil || sub.TrackVideo == nil {
+		router.subsLock.Unlock()
+		return 0, errors.New("router sub has no video")
+	}
+	sub.prefLayer = layer
+	if layer < sub.targetLayer || sub.control.Estimate() == 0 {
+		sub.targetLayer = layer
+	}
+	target, current := sub.targetLayer, sub.layer
+	router.subsLock.Unlock()
+
+	// 在新层的关键帧切换
+	if target != current {
+		router.RequestKeyframe(target)
+	}
+	return layer, nil
+}
+
+// adaptLayer 根据sub的带宽估计和丢包重新选择simulcast层,调用时持有subsLock
+func (router *Router) adaptLayer(sub *Sub) {
+	pub := router.pub
+	if pub == nil || pub.LayerCount() < 2 {
+		return
+	}
+
+	layer := sub.control.Select(sub.targetLayer, sub.prefLayer, router.layerRates[:pub.LayerCount()])
+	if layer == sub.targetLayer {
+		return
+	}
+	logger.Debugf("router sub layer %d -> %d, id=%s, sid=%s, bitrate=%d, rates=%v",
+		sub.targetLayer, layer, router.Id, sub.Id, sub.control.Estimate(), router.layerRates)
+	if layer > sub.targetLayer {
+		layerUp.Inc()
+	} else {
+		layerDown.Inc()
+	}
+	sub.targetLayer = layer
+	router.RequestKeyframe(layer)
+}
+
+// countLayer 统计每层推流的码率,调用时持有subsLock
+func (router *Router) countLayer(layer, size int) {
+	router.layerBytes[layer] += uint64(size)
+	elapsed := time.Since(router.rateTime)
+	if elapsed < rateCycle {
+		return
+	}
+	for i := range router.layerBytes {
+		router.layerRates[i] = router.layerBytes[i] * 8 * uint64(time.Second) / uint64(elapsed)
+		router.layerBytes[i] = 0
+	}
+	router.rateTime = time.Now()
+}
+
+// RequestKeyframe 向pub请求某一层的关键帧,每层pliCycle内只请求一次
+func (router *Router) RequestKeyframe(layer int) {
+	pub := router.pub
+	if pub == nil || layer < 0 || layer >= maxLayers {
+		return
+	}
+	ssrc := pub.LayerSSRC(layer)
+	if ssrc == 0 {
+		return
+	}
+
+	router.pliLock.Lock()
+	if time.Since(router.pliTime[layer]) < pliCycle {
+		router.pliLock.Unlock()
+		return
+	}
+	router.pliTime[layer] = time.Now()
+	router.pliLock.Unlock()
+
+	pub.WriteVideoRtcp(&rtcp.PictureLossIndication{MediaSSRC: ssrc})
+}
+
 // Alive 判断Router状态
 func (router *Router) Alive() bool {
 	if router.stop {
@@ -163,7 +322,8 @@ func (router *Router) Alive() bool {
 		bVideo := !router.videoAlive.Before(time.Now())
 		return (bAudio || bVideo)
 	}
-	return true
+	// 没有pub的router不会再有流
+	return false
 }
 
 // Close 关闭Router
@@ -197,8 +357,13 @@ func (router *Router) DoAudioWork() {
 					if sub.stop || !sub.alive {
 						sub.Close()
 						delete(router.subs, sid)
+					} else if router.audioMute {
+						metricDropped.WithLabelValues(webrtc.RTPCodecTypeAudio.String(), dropMute).Inc()
+					} else if sub.WriteAudioRtp(pkt) != nil {
+						metricDropped.WithLabelValues(webrtc.RTPCodecTypeAudio.String(), dropWrite).Inc()
 					} else {
-						sub.WriteAudioRtp(pkt)
+						audioPackets.Inc()
+						audioBytes.Add(float64(pkt.MarshalSize()))
 					}
 				}
 				router.subsLock.Unlock()
@@ -220,13 +385,32 @@ func (router *Router) DoVideoWork() {
 			pkt, err := router.pub.ReadVideoRTP()
 			if err == nil {
 				router.videoAlive = time.Now().Add(liveCycle)
+				codec := router.pub.VideoCodec()
+				keyframe := isKeyframe(codec, pkt.Payload)
+				layer := router.pub.Layer(pkt.SSRC)
+				router.videoCache[layer].Push(pkt)
 				router.subsLock.Lock()
+				router.countLayer(layer, pkt.MarshalSize())
 				for sid, sub := range router.subs {
 					if sub.stop || !sub.alive {
 						sub.Close()
 						delete(router.subs, sid)
+					} else if router.videoMute {
+						metricDropped.WithLabelValues(webrtc.RTPCodecTypeVideo.String(), dropMute).Inc()
+					} else if !sub.selectLayer(layer, keyframe) {
+						// 不是sub接收的simulcast层
+						continue
+					} else if sub.waitKeyframe && !keyframe {
+						// 等待关键帧,不能解码的包不发给sub
+						metricDropped.WithLabelValues(webrtc.RTPCodecTypeVideo.String(), dropKeyframe).Inc()
 					} else {
-						sub.WriteVideoRtp(pkt)
+						sub.waitKeyframe = false
+						if sub.WriteVideoRtp(pkt) != nil {
+							metricDropped.WithLabelValues(webrtc.RTPCodecTypeVideo.String(), dropWrite).Inc()
+						} else {
+							videoPackets.Inc()
+							videoBytes.Add(float64(pkt.MarshalSize()))
+						}
 					}
 				}
 				router.subsLock.Unlock()
@@ -237,7 +421,7 @@ func (router *Router) DoVideoWork() {
 	}
 }
 
-// DoRTCPWork 处理RTCP包,目前只用处理视频
+// DoRTCPWork 处理RTCP包,目前只用处理视频,NACK优先从缓存重传,REMB和RR用于选择simulcast层
 func (router *Router) DoRTCPWork(sub *Sub) {
 	for {
 		if router.stop || sub.TrackVideo == nil || sub.stop || !sub.alive {
@@ -249,24 +433,62 @@ func (router *Router) DoRTCPWork(sub *Sub) {
 			if err == nil {
 				switch (pkt).(type) {
 				case *rtcp.PictureLossIndication:
-					if router.pub != nil {
-						router.pub.WriteVideoRtcp(pkt)
-					}
+					_, target := sub.Layer()
+					router.RequestKeyframe(target)
 				case *rtcp.TransportLayerNack:
-					nack := (pkt).(*rtcp.TransportLayerNack)
-					for _, nackPair := range nack.Nacks {
-						nackpkt := &rtcp.TransportLayerNack{
-							SenderSSRC: nack.SenderSSRC,
-							MediaSSRC:  nack.MediaSSRC,
-							Nacks:      []rtcp.NackPair{{PacketID: nackPair.PacketID}},
-						}
-						if router.pub != nil {
-							router.pub.WriteVideoRtcp(nackpkt)
-						}
-					}
+					router.DoNack(sub, (pkt).(*rtcp.TransportLayerNack))
 				default:
+					router.subsLock.Lock()
+					if sub.control.Feedback(pkt, sub.rewriter.ssrc) {
+						router.adaptLayer(sub)
+					}
+					router.subsLock.Unlock()
 				}
 			}
 		}
 	}
 }
+
+// DoNack 从缓存重传sub丢失的包,缓存中没有的包合并后向pub请求,nackCycle内同一个包只请求一次
+func (router *Router) DoNack(sub *Sub, nack *rtcp.TransportLayerNack) {
+	pub := router.pub
+	if pub == nil {
+		return
+	}
+
+	misses := make([]uint16, 0)
+	router.subsLock.Lock()
+	layer, _ := sub.Layer()
+	cache := router.videoCache[layer]
+	for _, nackPair := range nack.Nacks {
+		for _, id := range nackPair.PacketList() {
+			// sub的序号是改写过的,换回当前层推流的序号
+			seq, ok := sub.rewriter.Restore(id)
+			if !ok {
+				continue
+			}
+			if pkt := cache.Get(seq); pkt != nil {
+				nackHit.Inc()
+				if sub.ResendVideoRtp(pkt) != nil {
+					metricDropped.WithLabelValues(webrtc.RTPCodecTypeVideo.String(), dropWrite).Inc()
+				}
+				continue
+			}
+			nackMiss.Inc()
+			if cache.NackOnce(seq) {
+				misses = append(misses, seq)
+			}
+		}
+	}
+	router.subsLock.Unlock()
+
+	if len(misses) == 0 {
+		return
+	}
+	metricNackUpstream.Add(float64(len(misses)))
+	pub.WriteVideoRtcp(&rtcp.TransportLayerNack{
+		SenderSSRC: nack.SenderSSRC,
+		MediaSSRC:  pub.LayerSSRC(layer),
+		Nacks:      nackPairs(misses),
+	})
+}
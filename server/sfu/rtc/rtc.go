@@ -42,6 +42,8 @@ func InitRTC() {
 		iceServers = append(iceServers, server)
 	}
 
+	initCodecs()
+
 	routers = make(map[string]*Router)
 	CleanRouter = make(chan string, maxCleanSize)
 
@@ -76,6 +78,20 @@ func GetRouters() map[string]*Router {
 	return routers
 }
 
+// GetCounts 获取router, pub, sub数量
+func GetCounts() (int, int, int) {
+	routersLock.Lock()
+	defer routersLock.Unlock()
+	pubs, subs := 0, 0
+	for _, router := range routers {
+		if router.GetPub() != nil {
+			pubs++
+		}
+		subs += router.GetSubCount()
+	}
+	return len(routers), pubs, subs
+}
+
 // GetRouter 获取Router
 func GetRouter(id string) *Router {
 	routersLock.Lock()
@@ -3,6 +3,7 @@ package rtc
 import (
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
@@ -19,30 +20,40 @@ type Pub struct {
 	stop  bool
 	alive bool
 	pc    *webrtc.PeerConnection
+	api   *webrtc.API
 
+	layerSSRCs []uint32
+	layers     map[uint32]*webrtc.RTPReceiver
+	layersLock sync.Mutex
+	done       chan struct{}
+	readers    sync.WaitGroup
 	TrackAudio *webrtc.RTPReceiver
 	TrackVideo *webrtc.RTPReceiver
 	RtpAudioCh chan *rtp.Packet
 	RtpVideoCh chan *rtp.Packet
 }
 
-func NewPub(pid string) (*Pub, error) {
+// NewPub 新建Pub对象,按配置的编码优先级协商offer中的编码
+// offer中有a=ssrc-group:SIM时接收每层视频
+func NewPub(pid string, offer webrtc.SessionDescription) (*Pub, error) {
 	cfg := webrtc.Configuration{
 		ICEServers:         iceServers,
 		ICETransportPolicy: webrtc.ICETransportPolicyAll,
 		SDPSemantics:       webrtc.SDPSemanticsUnifiedPlanWithFallback,
 	}
 
-	engine := webrtc.MediaEngine{}
-	engine.RegisterCodec(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
-	engine.RegisterCodec(webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	engine, err := newPubEngine(offer)
+	if err != nil {
+		logger.Errorf("pub media engine err=%v, pubid=%s", err, pid)
+		return nil, err
+	}
 
 	setting := webrtc.SettingEngine{}
 	if icePortStart != 0 && icePortEnd != 0 {
 		setting.SetEphemeralUDPPortRange(icePortStart, icePortEnd)
 	}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(engine), webrtc.WithSettingEngine(setting))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(*engine), webrtc.WithSettingEngine(setting))
 	pcnew, err := api.NewPeerConnection(cfg)
 	if err != nil {
 		logger.Errorf("pub new peer err=%v, pubid=%s", err, pid)
@@ -66,14 +77,22 @@ func NewPub(pid string) (*Pub, error) {
 	pub := &Pub{
 		Id:         pid,
 		pc:         pcnew,
+		api:        api,
 		stop:       false,
 		alive:      true,
+		layerSSRCs: simulcastSSRCs(offer),
+		layers:     make(map[uint32]*webrtc.RTPReceiver),
+		done:       make(chan struct{}),
 		TrackAudio: nil,
 		TrackVideo: nil,
 		RtpAudioCh: make(chan *rtp.Packet, maxRTPChanSize),
 		RtpVideoCh: make(chan *rtp.Packet, maxRTPChanSize),
 	}
 
+	if len(pub.layerSSRCs) == 0 && simulcastRids(offer) {
+		logger.Warnf("pub offer uses rid simulcast, only the first layer is forwarded, pubid=%s", pid)
+	}
+
 	pcnew.OnConnectionStateChange(pub.OnPeerConnect)
 	pcnew.OnTrack(pub.OnTrackRemote)
 	return pub, nil
@@ -100,22 +119,116 @@ func (pub *Pub) OnTrackRemote(track *webrtc.Track, receiver *webrtc.RTPReceiver)
 	if track.Kind() == webrtc.RTPCodecTypeAudio {
 		pub.TrackAudio = receiver
 		logger.Debugf("OnTrackRemote pub audio = %s", pub.Id)
-		go pub.DoAudioRtp()
+		pub.startReader(pub.DoAudioRtp)
 	}
 	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		// plan-b时simulcast的每层都会回调
+		if pub.TrackVideo != nil {
+			pub.AddLayer(track.SSRC(), receiver)
+			return
+		}
 		pub.TrackVideo = receiver
-		logger.Debugf("OnTrackRemote pub video = %s", pub.Id)
-		go pub.DoVideoRtp()
+		logger.Debugf("OnTrackRemote pub video = %s, layers = %v", pub.Id, pub.layerSSRCs)
+		pub.startReader(pub.DoVideoRtp)
+		pub.AddLayer(track.SSRC(), receiver)
+		pub.ReceiveLayers()
 	}
 }
 
-// Close 关闭连接
+// ReceiveLayers 接收simulcast的其他层,unified plan时pion只接收第一个ssrc
+func (pub *Pub) ReceiveLayers() {
+	for _, ssrc := range pub.layerSSRCs {
+		pub.layersLock.Lock()
+		_, ok := pub.layers[ssrc]
+		pub.layersLock.Unlock()
+		if ok {
+			continue
+		}
+
+		receiver, err := pub.api.NewRTPReceiver(webrtc.RTPCodecTypeVideo, pub.TrackVideo.Transport())
+		if err != nil {
+			logger.Errorf("pub new layer receiver err=%v, pubid=%s, ssrc=%d", err, pub.Id, ssrc)
+			continue
+		}
+		err = receiver.Receive(webrtc.RTPReceiveParameters{Encodings: webrtc.RTPDecodingParameters{
+			RTPCodingParameters: webrtc.RTPCodingParameters{SSRC: ssrc},
+		}})
+		if err != nil {
+			logger.Errorf("pub layer receive err=%v, pubid=%s, ssrc=%d", err, pub.Id, ssrc)
+			continue
+		}
+		pub.AddLayer(ssrc, receiver)
+	}
+}
+
+// AddLayer 增加一层视频,不是主track时启动读包线程
+func (pub *Pub) AddLayer(ssrc uint32, receiver *webrtc.RTPReceiver) {
+	pub.layersLock.Lock()
+	defer pub.layersLock.Unlock()
+	if pub.stop {
+		receiver.Stop()
+		return
+	}
+	if _, ok := pub.layers[ssrc]; ok {
+		return
+	}
+	pub.layers[ssrc] = receiver
+	if receiver != pub.TrackVideo {
+		logger.Debugf("pub add layer = %s, ssrc = %d", pub.Id, ssrc)
+		pub.readers.Add(1)
+		go func() {
+			defer pub.readers.Done()
+			pub.DoLayerRtp(receiver)
+		}()
+	}
+}
+
+// startReader 启动读包线程,关闭后不再启动
+func (pub *Pub) startReader(read func()) {
+	pub.layersLock.Lock()
+	defer pub.layersLock.Unlock()
+	if pub.stop {
+		return
+	}
+	pub.readers.Add(1)
+	go func() {
+		defer pub.readers.Done()
+		read()
+	}()
+}
+
+// Close 关闭连接,读包线程都退出后再关闭通道
 func (pub *Pub) Close() {
 	logger.Debugf("pub close = %s", pub.Id)
+	pub.layersLock.Lock()
+	if pub.stop {
+		pub.layersLock.Unlock()
+		return
+	}
 	pub.stop = true
+	close(pub.done)
+	for _, receiver := range pub.layers {
+		if receiver != pub.TrackVideo {
+			receiver.Stop()
+		}
+	}
+	pub.layersLock.Unlock()
 	pub.pc.Close()
-	close(pub.RtpAudioCh)
-	close(pub.RtpVideoCh)
+	go func() {
+		pub.readers.Wait()
+		close(pub.RtpAudioCh)
+		close(pub.RtpVideoCh)
+	}()
+}
+
+// push 把包放入通道,关闭时返回false
+func (pub *Pub) push(ch chan *rtp.Packet, pkt *rtp.Packet) bool {
+	select {
+	case ch <- pkt:
+		return true
+	case <-pub.done:
+		return false
+	}
 }
 
 // Answer SDP交换
@@ -158,7 +271,9 @@ func (pub *Pub) DoAudioRtp() {
 				if pub.stop || !pub.alive {
 					return
 				}
-				pub.RtpAudioCh <- rtp
+				if !pub.push(pub.RtpAudioCh, rtp) {
+					return
+				}
 			}
 		}
 	}
@@ -182,7 +297,32 @@ func (pub *Pub) DoVideoRtp() {
 				if pub.stop || !pub.alive {
 					return
 				}
-				pub.RtpVideoCh <- rtp
+				if !pub.push(pub.RtpVideoCh, rtp) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// DoLayerRtp 处理simulcast其他层的RTP包,和主track放入同一个通道
+func (pub *Pub) DoLayerRtp(receiver *webrtc.RTPReceiver) {
+	for {
+		if pub.stop || !pub.alive {
+			return
+		}
+
+		rtp, err := receiver.Track().ReadRTP()
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+		} else {
+			if pub.stop || !pub.alive {
+				return
+			}
+			if !pub.push(pub.RtpVideoCh, rtp) {
+				return
 			}
 		}
 	}
@@ -206,6 +346,55 @@ func (pub *Pub) ReadVideoRTP() (*rtp.Packet, error) {
 	return rtp, nil
 }
 
+// Codecs 返回推流使用的编码
+func (pub *Pub) Codecs() []*webrtc.RTPCodec {
+	codecs := make([]*webrtc.RTPCodec, 0)
+	if pub.TrackAudio != nil && pub.TrackAudio.Track() != nil {
+		codecs = append(codecs, pub.TrackAudio.Track().Codec())
+	}
+	if pub.TrackVideo != nil && pub.TrackVideo.Track() != nil {
+		codecs = append(codecs, pub.TrackVideo.Track().Codec())
+	}
+	return codecs
+}
+
+// VideoCodec 返回视频编码名称
+func (pub *Pub) VideoCodec() string {
+	if pub.TrackVideo != nil && pub.TrackVideo.Track() != nil {
+		return pub.TrackVideo.Track().Codec().Name
+	}
+	return ""
+}
+
+// LayerCount 返回视频层数,没有simulcast时为1
+func (pub *Pub) LayerCount() int {
+	if len(pub.layerSSRCs) == 0 {
+		return 1
+	}
+	return len(pub.layerSSRCs)
+}
+
+// Layer 根据ssrc返回视频层,0为最低层
+func (pub *Pub) Layer(ssrc uint32) int {
+	for layer, layerSSRC := range pub.layerSSRCs {
+		if layerSSRC == ssrc {
+			return layer
+		}
+	}
+	return 0
+}
+
+// LayerSSRC 返回视频层的ssrc,没有时返回0
+func (pub *Pub) LayerSSRC(layer int) uint32 {
+	if layer >= 0 && layer < len(pub.layerSSRCs) {
+		return pub.layerSSRCs[layer]
+	}
+	if layer == 0 && pub.TrackVideo != nil && pub.TrackVideo.Track() != nil {
+		return pub.TrackVideo.Track().SSRC()
+	}
+	return 0
+}
+
 // WriteVideoRtcp 发RTCP包
 func (pub *Pub) WriteVideoRtcp(pkg rtcp.Packet) error {
 	if pub.pc != nil {
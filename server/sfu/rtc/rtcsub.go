@@ -2,6 +2,7 @@ package rtc
 
 import (
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/pion/rtcp"
@@ -21,31 +22,42 @@ type Sub struct {
 	alive bool
 	pc    *webrtc.PeerConnection
 
-	writeErrCnt int
-	TrackAudio  *webrtc.RTPSender
-	TrackVideo  *webrtc.RTPSender
-	RtcpAudioCh chan rtcp.Packet
-	RtcpVideoCh chan rtcp.Packet
+	engine       *webrtc.MediaEngine
+	audioPT      uint8
+	videoPT      uint8
+	waitKeyframe bool
+	layer        int
+	targetLayer  int
+	prefLayer    int
+	rewriter     *layerRewriter
+	control      *layerControl
+	writeErrCnt  int
+	TrackAudio   *webrtc.RTPSender
+	TrackVideo   *webrtc.RTPSender
+	RtcpAudioCh  chan rtcp.Packet
+	RtcpVideoCh  chan rtcp.Packet
 }
 
-// NewSub 新建Sub对象
-func NewSub(sid string) (*Sub, error) {
+// NewSub 新建Sub对象,只协商推流使用的编码
+func NewSub(sid string, offer webrtc.SessionDescription, codecs []*webrtc.RTPCodec) (*Sub, error) {
 	cfg := webrtc.Configuration{
 		ICEServers:         iceServers,
 		ICETransportPolicy: webrtc.ICETransportPolicyAll,
 		SDPSemantics:       webrtc.SDPSemanticsUnifiedPlanWithFallback,
 	}
 
-	engine := webrtc.MediaEngine{}
-	engine.RegisterCodec(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
-	engine.RegisterCodec(webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	engine, err := newSubEngine(offer, codecs)
+	if err != nil {
+		logger.Errorf("sub media engine err=%v, sid=%s", err, sid)
+		return nil, err
+	}
 
 	setting := webrtc.SettingEngine{}
 	if icePortStart != 0 && icePortEnd != 0 {
 		setting.SetEphemeralUDPPortRange(icePortStart, icePortEnd)
 	}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(engine), webrtc.WithSettingEngine(setting))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(*engine), webrtc.WithSettingEngine(setting))
 	pcnew, err := api.NewPeerConnection(cfg)
 	if err != nil {
 		logger.Errorf("sub new peer err=%v, sid=%s", err, sid)
@@ -55,6 +67,7 @@ func NewSub(sid string) (*Sub, error) {
 	sub := &Sub{
 		Id:          sid,
 		pc:          pcnew,
+		engine:      engine,
 		stop:        false,
 		alive:       true,
 		writeErrCnt: 0,
@@ -94,9 +107,15 @@ func (sub *Sub) Close() {
 	close(sub.RtcpVideoCh)
 }
 
-// AddTrack 增加Track
+// AddTrack 增加Track,使用sub协商的payload type
 func (sub *Sub) AddTrack(remoteTrack *webrtc.Track) error {
-	track, err := sub.pc.NewTrack(remoteTrack.PayloadType(), remoteTrack.SSRC(), remoteTrack.ID(), remoteTrack.Label())
+	codecs := sub.engine.GetCodecsByName(remoteTrack.Codec().Name)
+	if len(codecs) == 0 {
+		return fmt.Errorf("sub codec %s not negotiated", remoteTrack.Codec().Name)
+	}
+
+	pt := codecs[0].PayloadType
+	track, err := sub.pc.NewTrack(pt, remoteTrack.SSRC(), remoteTrack.ID(), remoteTrack.Label())
 	if err != nil {
 		logger.Errorf("sub new track err=%v, sid=%s", err, sub.Id)
 		return err
@@ -110,9 +129,14 @@ func (sub *Sub) AddTrack(remoteTrack *webrtc.Track) error {
 
 	if remoteTrack.Kind() == webrtc.RTPCodecTypeAudio {
 		sub.TrackAudio = sender
+		sub.audioPT = pt
 	}
 	if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
 		sub.TrackVideo = sender
+		sub.videoPT = pt
+		sub.waitKeyframe = true
+		sub.rewriter = newLayerRewriter(remoteTrack.SSRC())
+		sub.control = newLayerControl()
 	}
 	return nil
 }
@@ -207,22 +231,59 @@ func (sub *Sub) ReadVideoRTCP() (rtcp.Packet, error) {
 	return pkt, nil
 }
 
+// rewritePT payload type和推流不同时复制包头并修改,包被所有sub共用
+func rewritePT(pkt *rtp.Packet, pt uint8) *rtp.Packet {
+	if pkt.PayloadType == pt {
+		return pkt
+	}
+	out := *pkt
+	out.PayloadType = pt
+	return &out
+}
+
 // WriteAudioRtp 写音频包
 func (sub *Sub) WriteAudioRtp(pkt *rtp.Packet) error {
 	if sub.TrackAudio != nil && sub.TrackAudio.Track() != nil && !sub.stop && sub.alive {
-		return sub.TrackAudio.Track().WriteRTP(pkt)
+		return sub.TrackAudio.Track().WriteRTP(rewritePT(pkt, sub.audioPT))
 	}
 	return errors.New("sub audio track is nil or peer not connect")
 }
 
-// WriteVideoRtp 写视频包
+// WriteVideoRtp 写视频包,改写成sub track的ssrc和连续的序号
 func (sub *Sub) WriteVideoRtp(pkt *rtp.Packet) error {
 	if sub.TrackVideo != nil && sub.TrackVideo.Track() != nil && !sub.stop && sub.alive {
-		return sub.TrackVideo.Track().WriteRTP(pkt)
+		return sub.TrackVideo.Track().WriteRTP(sub.rewriter.Rewrite(pkt, sub.videoPT))
 	}
 	return errors.New("sub video track is nil or peer not connect")
 }
 
+// ResendVideoRtp 重传缓存中的视频包,调用时持有router的subsLock
+func (sub *Sub) ResendVideoRtp(pkt *rtp.Packet) error {
+	if sub.TrackVideo != nil && sub.TrackVideo.Track() != nil && !sub.stop && sub.alive {
+		return sub.TrackVideo.Track().WriteRTP(sub.rewriter.Retransmit(pkt, sub.videoPT))
+	}
+	return errors.New("sub video track is nil or peer not connect")
+}
+
+// Layer 返回当前转发的视频层和希望接收的视频层
+func (sub *Sub) Layer() (int, int) {
+	return sub.layer, sub.targetLayer
+}
+
+// selectLayer 判断是否转发layer层的包,目标层的关键帧到达时切换
+func (sub *Sub) selectLayer(layer int, keyframe bool) bool {
+	if layer == sub.layer {
+		return true
+	}
+	if layer != sub.targetLayer || !keyframe {
+		return false
+	}
+	sub.layer = layer
+	sub.waitKeyframe = false
+	sub.rewriter.Switch()
+	return true
+}
+
 // WriteErrTotal return write error
 func (sub *Sub) WriteErrTotal() int {
 	return sub.writeErrCnt
@@ -0,0 +1,155 @@
+package rtc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	// maxLayers simulcast最多的层数
+	maxLayers = 3
+	// videoClockRate 视频时间戳的时钟频率
+	videoClockRate = 90000
+)
+
+// simulcastSSRCs 解析offer视频中的a=ssrc-group:SIM,按从低到高的顺序返回每层的ssrc
+// pion不支持rid,只支持通过ssrc声明的simulcast
+func simulcastSSRCs(offer webrtc.SessionDescription) []uint32 {
+	desc := sdp.SessionDescription{}
+	if desc.Unmarshal([]byte(offer.SDP)) != nil {
+		return nil
+	}
+
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != webrtc.RTPCodecTypeVideo.String() {
+			continue
+		}
+		for _, attr := range media.Attributes {
+			if attr.Key != sdp.AttrKeySSRCGroup {
+				continue
+			}
+			fields := strings.Fields(attr.Value)
+			if len(fields) < 3 || fields[0] != "SIM" {
+				continue
+			}
+			ssrcs := make([]uint32, 0, maxLayers)
+			for _, field := range fields[1:] {
+				ssrc, err := strconv.ParseUint(field, 10, 32)
+				if err != nil {
+					return nil
+				}
+				if len(ssrcs) < maxLayers {
+					ssrcs = append(ssrcs, uint32(ssrc))
+				}
+			}
+			return ssrcs
+		}
+	}
+	return nil
+}
+
+// simulcastRids 判断offer视频是否用a=rid声明了simulcast,pion不支持这种方式
+func simulcastRids(offer webrtc.SessionDescription) bool {
+	desc := sdp.SessionDescription{}
+	if desc.Unmarshal([]byte(offer.SDP)) != nil {
+		return false
+	}
+
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != webrtc.RTPCodecTypeVideo.String() {
+			continue
+		}
+		for _, attr := range media.Attributes {
+			if attr.Key == "rid" || attr.Key == "simulcast" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seqNewer 判断序号a是否比b新,考虑回绕
+func seqNewer(a, b uint16) bool {
+	return a != b && a-b < 0x8000
+}
+
+// layerRewriter 改写sub收到的视频包的ssrc,序号和时间戳,切换层时sub看到的是连续的一路流
+type layerRewriter struct {
+	ssrc      uint32
+	started   bool
+	switching bool
+	seqOffset uint16
+	tsOffset  uint32
+	baseSeq   uint16
+	lastSeq   uint16
+	lastTs    uint32
+	lastTime  time.Time
+}
+
+// newLayerRewriter 创建改写对象,ssrc为sub track的ssrc
+func newLayerRewriter(ssrc uint32) *layerRewriter {
+	return &layerRewriter{ssrc: ssrc}
+}
+
+// Switch 下一个包来自新的层,重新计算偏移
+func (r *layerRewriter) Switch() {
+	r.switching = true
+}
+
+// Rewrite 复制包头并改写,包被所有sub共用不能直接修改
+func (r *layerRewriter) Rewrite(pkt *rtp.Packet, pt uint8) *rtp.Packet {
+	if !r.started {
+		r.started = true
+		r.switching = false
+		r.baseSeq = pkt.SequenceNumber
+		r.lastSeq = pkt.SequenceNumber - 1
+		r.lastTs = pkt.Timestamp
+		r.lastTime = time.Now()
+	} else if r.switching {
+		// 新层的第一个包紧接上一层的最后一个包,时间戳按实际经过的时间增加
+		r.switching = false
+		elapsed := uint32(time.Since(r.lastTime) * videoClockRate / time.Second)
+		if elapsed == 0 {
+			elapsed = 1
+		}
+		r.seqOffset = pkt.SequenceNumber - r.lastSeq - 1
+		r.tsOffset = pkt.Timestamp - r.lastTs - elapsed
+		r.baseSeq = r.lastSeq + 1
+	}
+
+	out := r.apply(pkt, pt)
+	if seqNewer(out.SequenceNumber, r.lastSeq) {
+		r.lastSeq = out.SequenceNumber
+		r.lastTs = out.Timestamp
+		r.lastTime = time.Now()
+	}
+	return out
+}
+
+// Retransmit 改写缓存中的包用于重传,不改变偏移
+func (r *layerRewriter) Retransmit(pkt *rtp.Packet, pt uint8) *rtp.Packet {
+	return r.apply(pkt, pt)
+}
+
+// apply 按当前的偏移复制并改写包头
+func (r *layerRewriter) apply(pkt *rtp.Packet, pt uint8) *rtp.Packet {
+	out := *pkt
+	out.SSRC = r.ssrc
+	out.PayloadType = pt
+	out.SequenceNumber = pkt.SequenceNumber - r.seqOffset
+	out.Timestamp = pkt.Timestamp - r.tsOffset
+	return &out
+}
+
+// Restore 把sub的序号换回当前层推流的序号,切换层之前的序号返回false
+func (r *layerRewriter) Restore(seq uint16) (uint16, bool) {
+	if !r.started || seqNewer(r.baseSeq, seq) {
+		return 0, false
+	}
+	return seq + r.seqOffset, true
+}
@@ -0,0 +1,223 @@
+package rtc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+func TestSeqNewer(t *testing.T) {
+	tests := []struct {
+		a, b uint16
+		want bool
+	}{
+		{1, 0, true},
+		{0, 1, false},
+		{5, 5, false},
+		{0x7fff, 0, true},
+		{0x8000, 0, false},
+		{0, 0xffff, true},
+		{0xffff, 0, false},
+		{10, 0xfff0, true},
+	}
+
+	for _, tt := range tests {
+		if got := seqNewer(tt.a, tt.b); got != tt.want {
+			t.Errorf("seqNewer(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func testPacket(ssrc uint32, seq uint16, ts uint32) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 100, SSRC: ssrc, SequenceNumber: seq, Timestamp: ts},
+		Payload: []byte{0x10, 0x50},
+	}
+}
+
+func TestLayerRewriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    [][2]uint32
+		second   [][2]uint32
+		wantSeqs []uint16
+	}{
+		{
+			name:     "no wrap",
+			first:    [][2]uint32{{1000, 3000}, {1001, 6000}},
+			second:   [][2]uint32{{20000, 90000}, {20001, 93000}},
+			wantSeqs: []uint16{1000, 1001, 1002, 1003},
+		},
+		{
+			name:     "wrap before switch",
+			first:    [][2]uint32{{65534, 3000}, {65535, 6000}, {0, 9000}},
+			second:   [][2]uint32{{100, 90000}, {101, 93000}},
+			wantSeqs: []uint16{65534, 65535, 0, 1, 2},
+		},
+		{
+			name:     "wrap after switch",
+			first:    [][2]uint32{{500, 3000}, {501, 6000}},
+			second:   [][2]uint32{{65535, 90000}, {0, 93000}, {1, 96000}},
+			wantSeqs: []uint16{500, 501, 502, 503, 504},
+		},
+		{
+			name:     "timestamp wrap",
+			first:    [][2]uint32{{10, 0xfffff000}, {11, 0xfffffb00}},
+			second:   [][2]uint32{{300, 1000}, {301, 4000}},
+			wantSeqs: []uint16{10, 11, 12, 13},
+		},
+	}
+
+	for _, tt := range tests {
+		r := newLayerRewriter(1234)
+		seqs := make([]uint16, 0)
+		var lastTs uint32
+		for i, p := range tt.first {
+			out := r.Rewrite(testPacket(1, uint16(p[0]), p[1]), 96)
+			if out.SSRC != 1234 || out.PayloadType != 96 {
+				t.Errorf("%s: ssrc = %d pt = %d, want 1234 96", tt.name, out.SSRC, out.PayloadType)
+			}
+			// 第一层不改写序号和时间戳
+			if out.Timestamp != p[1] {
+				t.Errorf("%s: packet %d timestamp = %d, want %d", tt.name, i, out.Timestamp, p[1])
+			}
+			seqs = append(seqs, out.SequenceNumber)
+			lastTs = out.Timestamp
+		}
+
+		r.Switch()
+		for i, p := range tt.second {
+			in := testPacket(2, uint16(p[0]), p[1])
+			out := r.Rewrite(in, 96)
+			if in.SSRC != 2 || in.SequenceNumber != uint16(p[0]) || in.Timestamp != p[1] {
+				t.Errorf("%s: input packet modified", tt.name)
+			}
+			// 时间戳按实际经过的时间增加,测试中不会超过1秒
+			if i == 0 && (out.Timestamp-lastTs == 0 || out.Timestamp-lastTs > videoClockRate) {
+				t.Errorf("%s: timestamp after switch = %d, last = %d", tt.name, out.Timestamp, lastTs)
+			}
+			if i > 0 && out.Timestamp-lastTs != p[1]-tt.second[i-1][1] {
+				t.Errorf("%s: timestamp step = %d, want %d", tt.name, out.Timestamp-lastTs, p[1]-tt.second[i-1][1])
+			}
+			seqs = append(seqs, out.SequenceNumber)
+			lastTs = out.Timestamp
+		}
+
+		if !reflect.DeepEqual(seqs, tt.wantSeqs) {
+			t.Errorf("%s: seqs = %v, want %v", tt.name, seqs, tt.wantSeqs)
+		}
+	}
+}
+
+func TestLayerRewriterRestore(t *testing.T) {
+	r := newLayerRewriter(1234)
+	if _, ok := r.Restore(0); ok {
+		t.Errorf("restore before start should fail")
+	}
+
+	r.Rewrite(testPacket(1, 65534, 3000), 96)
+	r.Rewrite(testPacket(1, 65535, 6000), 96)
+	if seq, ok := r.Restore(65535); !ok || seq != 65535 {
+		t.Errorf("restore(65535) = %d %v, want 65535 true", seq, ok)
+	}
+
+	// 切换后sub看到的序号0,1对应新层的100,101
+	r.Switch()
+	r.Rewrite(testPacket(2, 100, 90000), 96)
+	r.Rewrite(testPacket(2, 101, 93000), 96)
+
+	tests := []struct {
+		seq  uint16
+		want uint16
+		ok   bool
+	}{
+		{0, 100, true},
+		{1, 101, true},
+		{5, 105, true},
+		{65535, 0, false},
+		{65000, 0, false},
+	}
+	for _, tt := range tests {
+		seq, ok := r.Restore(tt.seq)
+		if ok != tt.ok || (ok && seq != tt.want) {
+			t.Errorf("restore(%d) = %d %v, want %d %v", tt.seq, seq, ok, tt.want, tt.ok)
+		}
+	}
+
+	// 重传不改变偏移
+	out := r.Retransmit(testPacket(2, 100, 90000), 96)
+	if out.SequenceNumber != 0 || out.SSRC != 1234 {
+		t.Errorf("retransmit seq = %d ssrc = %d, want 0 1234", out.SequenceNumber, out.SSRC)
+	}
+	if next := r.Rewrite(testPacket(2, 102, 96000), 96); next.SequenceNumber != 2 {
+		t.Errorf("seq after retransmit = %d, want 2", next.SequenceNumber)
+	}
+}
+
+func testOffer(lines ...string) webrtc.SessionDescription {
+	head := []string{
+		"v=0",
+		"o=- 4611731400430051336 2 IN IP4 127.0.0.1",
+		"s=-",
+		"t=0 0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"c=IN IP4 0.0.0.0",
+		"a=rtpmap:111 opus/48000/2",
+		"a=ssrc:10 cname:test",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"c=IN IP4 0.0.0.0",
+		"a=rtpmap:96 VP8/90000",
+	}
+	sdp := strings.Join(append(head, lines...), "\r\n") + "\r\n"
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+}
+
+func TestSimulcastSSRCs(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer webrtc.SessionDescription
+		want  []uint32
+	}{
+		{"no simulcast", testOffer("a=ssrc:1 cname:test"), nil},
+		{"fid only", testOffer("a=ssrc-group:FID 1 2", "a=ssrc:1 cname:test", "a=ssrc:2 cname:test"), nil},
+		{"chrome sim", testOffer(
+			"a=ssrc-group:FID 1 2",
+			"a=ssrc-group:SIM 1 3 5",
+			"a=ssrc-group:FID 3 4",
+			"a=ssrc-group:FID 5 6",
+		), []uint32{1, 3, 5}},
+		{"two layers", testOffer("a=ssrc-group:SIM 3735928559 4294967295"), []uint32{3735928559, 4294967295}},
+		{"more than max layers", testOffer("a=ssrc-group:SIM 1 2 3 4"), []uint32{1, 2, 3}},
+		{"single ssrc", testOffer("a=ssrc-group:SIM 1"), nil},
+		{"bad ssrc", testOffer("a=ssrc-group:SIM 1 x 3"), nil},
+		{"invalid sdp", webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "bad"}, nil},
+	}
+
+	for _, tt := range tests {
+		got := simulcastSSRCs(tt.offer)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: simulcastSSRCs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimulcastRids(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer webrtc.SessionDescription
+		want  bool
+	}{
+		{"no simulcast", testOffer("a=ssrc:1 cname:test"), false},
+		{"ssrc sim", testOffer("a=ssrc-group:SIM 1 3 5"), false},
+		{"rid", testOffer("a=rid:q send", "a=rid:h send", "a=rid:f send", "a=simulcast:send q;h;f"), true},
+	}
+
+	for _, tt := range tests {
+		if got := simulcastRids(tt.offer); got != tt.want {
+			t.Errorf("%s: simulcastRids = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zhuanxin-sz/go-protoo/logger"
 	nprotoo "github.com/zhuanxin-sz/nats-protoo"
 )
@@ -92,5 +93,6 @@ func UpdatePayload() {
 
 func debug() {
 	logger.Debugf("Start sfu pprof on %s", conf.Global.Pprof)
+	http.Handle("/metrics", promhttp.Handler())
 	http.ListenAndServe(conf.Global.Pprof, nil)
 }
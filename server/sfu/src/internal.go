@@ -33,6 +33,10 @@ func handleRPCRequest(request map[string]interface{}, accept nprotoo.AcceptFunc,
 			result, err = subscribe(data)
 		case proto.BizToSfuUnSubscribe:
 			result, err = unsubscribe(data)
+		case proto.BizToSfuMute:
+			result, err = mute(data)
+		case proto.BizToSfuSetLayer:
+			result, err = setlayer(data)
 		}
 	}
 	if err != nil {
@@ -72,6 +76,7 @@ func publish(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error
 	// 增加推流
 	resp, err := router.AddPub(mid, sdp)
 	if err != nil {
+		rtc.DelRouter(key)
 		return nil, &nprotoo.Error{Code: 404, Reason: fmt.Sprintf("add pub err:%v", err)}
 	}
 	return util.Map("mid", mid, "jsep", util.Map("type", "answer", "sdp", resp)), nil
@@ -155,3 +160,55 @@ func unsubscribe(msg map[string]interface{}) (map[string]interface{}, *nprotoo.E
 	router.DelSub(sid)
 	return util.Map(), nil
 }
+
+/*
+	"method", proto.BizToSfuMute, "rid", rid, "mid", mid, "kind", kind, "mute", mute
+*/
+// mute 停止或恢复转发流
+func mute(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	// 获取参数
+	rid := util.Val(msg, "rid")
+	mid := util.Val(msg, "mid")
+	kind := util.Val(msg, "kind")
+	muted, _ := msg["mute"].(bool)
+	uid := proto.GetUIDFromMID(mid)
+
+	// 获取router
+	key := proto.GetMediaPubKey(rid, uid, mid)
+	router := rtc.GetRouter(key)
+	if router == nil {
+		return nil, &nprotoo.Error{Code: 410, Reason: fmt.Sprintf("can't get router:%s", key)}
+	}
+
+	err := router.SetMute(kind, muted)
+	if err != nil {
+		return nil, &nprotoo.Error{Code: 411, Reason: fmt.Sprintf("mute err:%v", err)}
+	}
+	return util.Map(), nil
+}
+
+/*
+	"method", proto.BizToSfuSetLayer, "rid", rid, "mid", mid, "sid", sid, "layer", layer
+*/
+// setlayer 选择sub接收的simulcast层
+func setlayer(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+	// 获取参数
+	rid := util.Val(msg, "rid")
+	mid := util.Val(msg, "mid")
+	sid := util.Val(msg, "sid")
+	layer := util.InterfaceToInt(msg["layer"])
+	uid := proto.GetUIDFromMID(mid)
+
+	// 获取router
+	key := proto.GetMediaPubKey(rid, uid, mid)
+	router := rtc.GetRouter(key)
+	if router == nil {
+		return nil, &nprotoo.Error{Code: 410, Reason: fmt.Sprintf("can't get router:%s", key)}
+	}
+
+	layer, err := router.SetLayer(sid, layer)
+	if err != nil {
+		return nil, &nprotoo.Error{Code: 412, Reason: fmt.Sprintf("set layer err:%v", err)}
+	}
+	return util.Map("layer", layer), nil
+}